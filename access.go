@@ -0,0 +1,187 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// publicCollection is the special collection addressing everyone, which
+// may also be written in its compacted forms.
+const publicCollection = "https://www.w3.org/ns/activitystreams#Public"
+
+var publicCollectionNames = map[string]bool{
+	publicCollection: true,
+	"as:Public":      true,
+	"Public":         true,
+}
+
+// addressingProperties are the properties listing who an object is for.
+var addressingProperties = []string{"to", "cc", "bto", "bcc", "audience"}
+
+// authorProperties are the properties naming who made an object, who can
+// always see it.
+var authorProperties = []string{"attributedTo", "actor"}
+
+// ids returns the ids in a property that may hold an IRI, an embedded object
+// or an array of either.
+func ids(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case map[string]interface{}:
+		if id := stringValue(t["id"]); len(id) > 0 {
+			return []string{id}
+		}
+	case []interface{}:
+		var s []string
+		for _, e := range t {
+			s = append(s, ids(e)...)
+		}
+		return s
+	}
+	return nil
+}
+
+// visibleTo determines whether the serialized object m may be seen by user,
+// which is nil for anonymous requests. Objects with no addressing at all, or
+// addressed to the Public collection, are seen by everyone. Others are only
+// seen by their authors, their recipients, and the members of the local
+// collections they are addressed to.
+func (a *app) visibleTo(c context.Context, m map[string]interface{}, user *url.URL) bool {
+	var recipients []string
+	for _, p := range addressingProperties {
+		recipients = append(recipients, ids(m[p])...)
+	}
+	if len(recipients) == 0 {
+		return true
+	}
+	for _, r := range recipients {
+		if publicCollectionNames[r] {
+			return true
+		}
+	}
+	if user == nil {
+		return false
+	}
+	u := user.String()
+	for _, p := range authorProperties {
+		for _, id := range ids(m[p]) {
+			if id == u {
+				return true
+			}
+		}
+	}
+	for _, r := range recipients {
+		if r == u {
+			return true
+		}
+		if a.inLocalCollection(c, r, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// inLocalCollection determines whether the collection of a hosted actor with
+// the id collection holds member.
+func (a *app) inLocalCollection(c context.Context, collection, member string) bool {
+	id, err := url.Parse(collection)
+	if err != nil || !a.isCollection(id) {
+		return false
+	}
+	oc, err := a.getCollection(c, id)
+	if err != nil {
+		log.Printf("cannot check membership of %s: %s", id, err)
+		return false
+	}
+	m, err := oc.Serialize()
+	if err != nil {
+		log.Printf("cannot check membership of %s: %s", id, err)
+		return false
+	}
+	for _, item := range orderedItems(m) {
+		for _, itemId := range ids(item) {
+			if itemId == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requester returns the actor making r, authenticated by an OAuth token
+// authorized to read or by an HTTP signature as in authorized fetches, or nil
+// if r is anonymous.
+func (a *app) requester(c context.Context, r *http.Request) *url.URL {
+	if a.verifier != nil {
+		if user, authn, authz, err := a.verifier.Verify(r); err == nil && authn && user != nil {
+			if authz {
+				return user
+			}
+			log.Printf("treating GET of %q by %s as anonymous, not authorized to read", r.URL, user)
+		}
+	}
+	if len(r.Header.Get("Signature")) == 0 {
+		return nil
+	}
+	owner, err := a.verifyRequestSignature(c, r, fetchSignedHeaders)
+	if err != nil {
+		log.Printf("treating GET of %q as anonymous, invalid signature: %s", r.URL, err)
+		return nil
+	}
+	user, err := url.Parse(owner)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// checkVisible returns an error if o may not be seen by user.
+func (a *app) checkVisible(c context.Context, o pub.PubObject, user *url.URL) error {
+	m, err := o.Serialize()
+	if err != nil {
+		return err
+	}
+	if !a.visibleTo(c, m, user) {
+		return fmt.Errorf("%s is not visible to %v", o.GetId(), user)
+	}
+	return nil
+}
+
+// checkAccess hides the stored objects that are not addressed to whoever
+// fetches them: anonymous requests get a 404 as if they did not exist, and
+// authenticated ones a 403. Actors and collections are left to later
+// handlers. It has the signature of a pub.HandlerFunc, and handles only the
+// requests it rejects.
+func (a *app) checkAccess(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false, nil
+	}
+	id := a.resolveKey(r.URL)
+	if a.isActor(id) || a.isCollection(id) {
+		return false, nil
+	}
+	if has, err := a.Has(c, id); err != nil || !has {
+		return false, err
+	}
+	o, err := a.Get(c, id, pub.Read)
+	if err != nil {
+		return false, err
+	}
+	user := a.requester(c, r)
+	err = a.checkVisible(c, o, user)
+	if err == nil {
+		return false, nil
+	} else if user == nil {
+		log.Printf("hiding from anonymous GET: %s", err)
+		http.NotFound(w, r)
+		return true, nil
+	}
+	log.Printf("forbidding GET: %s", err)
+	http.Error(w, "forbidden", http.StatusForbidden)
+	return true, nil
+}
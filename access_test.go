@@ -0,0 +1,303 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/vocab"
+	"github.com/go-fed/httpsig"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapObject is a stored object kept as its serialization.
+type mapObject map[string]interface{}
+
+func (m mapObject) Serialize() (map[string]interface{}, error) { return m, nil }
+func (m mapObject) TypeLen() int                               { return 1 }
+func (m mapObject) GetType(i int) interface{}                  { return m["type"] }
+func (m mapObject) HasId() bool                                { return len(stringValue(m["id"])) > 0 }
+func (m mapObject) SetId(id *url.URL)                          { m["id"] = id.String() }
+func (m mapObject) AppendType(t interface{})                   {}
+func (m mapObject) RemoveType(i int)                           {}
+
+func (m mapObject) GetId() *url.URL {
+	u, _ := url.Parse(stringValue(m["id"]))
+	return u
+}
+
+// accessTest is a report server hosting alice, with peers hosting bob and
+// carol, serving stored objects and collections after access control.
+type accessTest struct {
+	app   *app
+	srv   *httptest.Server
+	alice *localActor
+	bob   *MockPeer
+	carol *MockPeer
+	next  int
+}
+
+func newAccessTest(t *testing.T) *accessTest {
+	at := &accessTest{}
+	mux := http.NewServeMux()
+	at.srv = httptest.NewServer(mux)
+	t.Cleanup(at.srv.Close)
+	host := at.srv.Listener.Addr().String()
+	authURL := &url.URL{Scheme: "http", Host: host, Path: authPath}
+	tokenURL := &url.URL{Scheme: "http", Host: host, Path: tokenPath}
+	stub := &doNotUseThisItIsNotOAuth{}
+	at.app = newApp("http", host, "/new", NewMemoryStore(), authURL, tokenURL, stub, &http.Client{}, &localClock{}, 0, defaultPageSize)
+	stub.app = at.app
+	var err error
+	if at.alice, err = at.app.addActor(context.Background(), ActorConfig{Name: "alice", Token: "alice-token"}); err != nil {
+		t.Fatal(err)
+	}
+	at.bob = newTestPeer(t, "bob")
+	at.carol = newTestPeer(t, "carol")
+
+	lockKey := 0
+	lockKeyMu := &sync.Mutex{}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Scheme = "http"
+		r.URL.Host = host
+		lockKeyMu.Lock()
+		lockKey++
+		c := context.WithValue(context.Background(), lockKeyName, lockKey)
+		lockKeyMu.Unlock()
+		for _, h := range []pub.HandlerFunc{at.app.serveCollection, at.app.checkAccess, at.serveStored} {
+			if handled, err := h(c, w, r); err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if handled {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	return at
+}
+
+// serveStored stands in for the object serving of go-fed/activity.
+func (at *accessTest) serveStored(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	o, err := at.app.Get(c, r.URL, pub.Read)
+	if err != nil {
+		return false, nil
+	}
+	m, err := o.Serialize()
+	if err != nil {
+		return true, err
+	}
+	w.Header().Set("Content-Type", activityJSONType)
+	writeJSON(w, http.StatusOK, m)
+	return true, nil
+}
+
+func newTestPeer(t *testing.T, name string) *MockPeer {
+	var peer *MockPeer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	var err error
+	if peer, err = NewMockPeer("http", srv.Listener.Addr().String(), name); err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+// store keeps a note by alice with the given addressing, returning its id.
+func (at *accessTest) store(t *testing.T, addressing map[string]interface{}) *url.URL {
+	at.next++
+	id := fmt.Sprintf("%s/new/%d", at.srv.URL, at.next)
+	note := mapObject{
+		"id":           id,
+		"type":         "Note",
+		"attributedTo": at.alice.actorURL.String(),
+		"content":      "hello",
+	}
+	for k, v := range addressing {
+		note[k] = v
+	}
+	if err := at.app.store.Put(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(id)
+	return u
+}
+
+// fetch GETs id as who: "" for anonymously, "alice" with her bearer token,
+// or a peer's name with a signed request.
+func (at *accessTest) fetch(t *testing.T, id *url.URL, who string) (int, map[string]interface{}) {
+	var resp *http.Response
+	var err error
+	switch who {
+	case "bob":
+		resp, err = at.bob.Fetch(&http.Client{}, id)
+	case "carol":
+		resp, err = at.carol.Fetch(&http.Client{}, id)
+	default:
+		req, _ := http.NewRequest(http.MethodGet, id.String(), nil)
+		req.Header.Set("Accept", activityJSONType)
+		if who == "alice" {
+			req.Header.Set("Authorization", "Bearer "+at.alice.token)
+		}
+		resp, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&m)
+	return resp.StatusCode, m
+}
+
+func (at *accessTest) addFollower(t *testing.T, follower *url.URL) {
+	oc := &vocab.OrderedCollection{}
+	oc.SetId(at.alice.followersURL)
+	oc.AppendOrderedItemsIRI(follower)
+	if err := at.app.store.Put(context.Background(), oc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccessToStoredObjects(t *testing.T) {
+	at := newAccessTest(t)
+	at.addFollower(t, at.bob.ActorIRI())
+	bob := at.bob.ActorIRI().String()
+	for _, test := range []struct {
+		name       string
+		addressing map[string]interface{}
+		anonymous  int
+		bob        int
+		carol      int
+	}{
+		{"public", map[string]interface{}{"to": publicCollection}, http.StatusOK, http.StatusOK, http.StatusOK},
+		{"unlisted", map[string]interface{}{"to": bob, "cc": []interface{}{"as:Public"}}, http.StatusOK, http.StatusOK, http.StatusOK},
+		{"to", map[string]interface{}{"to": []interface{}{bob}}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+		{"cc", map[string]interface{}{"cc": bob}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+		{"bto", map[string]interface{}{"bto": bob}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+		{"bcc", map[string]interface{}{"bcc": []interface{}{map[string]interface{}{"id": bob}}}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+		{"audience", map[string]interface{}{"audience": bob}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+		{"followers", map[string]interface{}{"to": at.alice.followersURL.String()}, http.StatusNotFound, http.StatusOK, http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id := at.store(t, test.addressing)
+			for _, who := range []struct {
+				name string
+				want int
+			}{
+				{"", test.anonymous},
+				{"bob", test.bob},
+				{"carol", test.carol},
+				{"alice", http.StatusOK},
+			} {
+				status, m := at.fetch(t, id, who.name)
+				if status != who.want {
+					t.Errorf("fetched by %q: got %d, want %d", who.name, status, who.want)
+				} else if status == http.StatusOK && stringValue(m["id"]) != id.String() {
+					t.Errorf("fetched by %q: got %v", who.name, m)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessWithInvalidSignatureIsAnonymous(t *testing.T) {
+	at := newAccessTest(t)
+	id := at.store(t, map[string]interface{}{"to": at.bob.ActorIRI().String()})
+	req, _ := http.NewRequest(http.MethodGet, id.String(), nil)
+	req.Header.Set("Accept", activityJSONType)
+	req.Header.Set("Date", "Mon, 01 Jan 2018 00:00:00 GMT")
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",headers="(request-target) host date",signature="AAAA"`, at.bob.ActorIRI()))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAccessSignedForAnotherHostIsAnonymous(t *testing.T) {
+	at := newAccessTest(t)
+	id := at.store(t, map[string]interface{}{"to": at.bob.ActorIRI().String()})
+	req, _ := http.NewRequest(http.MethodGet, id.String(), nil)
+	req.Header.Set("Accept", activityJSONType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", "elsewhere.example")
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, fetchSignedHeaders, httpsig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SignRequest(at.bob.privKey, at.bob.keyURL.String(), req); err != nil {
+		t.Fatal(err)
+	}
+	// The client sends the host of the URL, not the signed one.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAccessToCollectionItems(t *testing.T) {
+	at := newAccessTest(t)
+	bob := at.bob.ActorIRI().String()
+	activity := func(id string, addressing map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{
+			"id":    at.srv.URL + "/new/" + id,
+			"type":  "Create",
+			"actor": at.alice.actorURL.String(),
+		}
+		for k, v := range addressing {
+			m[k] = v
+		}
+		return m
+	}
+	outbox := &vocab.OrderedCollection{}
+	if err := outbox.Deserialize(map[string]interface{}{
+		"id":   at.alice.outboxURL.String(),
+		"type": "OrderedCollection",
+		"orderedItems": []interface{}{
+			activity("1", map[string]interface{}{"to": publicCollection}),
+			activity("2", map[string]interface{}{"to": bob}),
+			activity("3", map[string]interface{}{"bto": bob}),
+			at.srv.URL + "/new/4",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := at.app.store.Put(context.Background(), outbox); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := url.Parse(at.alice.outboxURL.String() + "?page=1")
+	for _, test := range []struct {
+		who  string
+		want int
+	}{
+		{"", 2},
+		{"carol", 2},
+		{"bob", 4},
+		{"alice", 4},
+	} {
+		status, m := at.fetch(t, page, test.who)
+		if status != http.StatusOK {
+			t.Fatalf("fetched by %q: got %d", test.who, status)
+		}
+		items, _ := m["orderedItems"].([]interface{})
+		if len(items) != test.want || int(m["totalItems"].(float64)) != test.want {
+			t.Errorf("fetched by %q: got %d of %v items, want %d", test.who, len(items), m["totalItems"], test.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package report
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/vocab"
+	"github.com/go-fed/httpsig"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	usersPath     = "/users/"
+	inboxPath     = "/inbox"
+	outboxPath    = "/outbox"
+	followingPath = "/following"
+	followersPath = "/followers"
+	likedPath     = "/liked"
+)
+
+// legacyActorPath is where the only actor used to be hosted, now redirecting
+// to the first actor.
+const legacyActorPath = "/actor"
+
+const (
+	defaultActorName  = "report"
+	defaultActorToken = "doNotDoThisInRealImplementations"
+)
+
+// actorNameRegexp restricts actor names to what fits in a path segment
+// without escaping.
+var actorNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ActorConfig describes one of the actors hosted by the report server.
+type ActorConfig struct {
+	// Name is the last path segment of the actor's id, /users/{Name}.
+	Name string `json:"name"`
+	// DisplayName is the human readable name of the actor. Defaults to
+	// Name.
+	DisplayName string `json:"displayName,omitempty"`
+	// Token is the bearer token that authorizes posting to the actor's
+	// outbox. Tokens must be unique among actors.
+	Token string `json:"token"`
+	// KeyFile is a PEM file with the actor's private key, created if it is
+	// missing. If empty, a new key is generated on each start.
+	KeyFile string `json:"keyFile,omitempty"`
+	// KeyBits is the size of a created RSA key.
+	KeyBits int `json:"keyBits,omitempty"`
+	// privKey overrides KeyFile and KeyBits when set.
+	privKey crypto.PrivateKey
+}
+
+// LoadActorConfigs reads the actors to host from a JSON file containing an
+// array of ActorConfig.
+func LoadActorConfigs(file string) ([]ActorConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []ActorConfig
+	if err := json.Unmarshal(b, &cfgs); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", file, err)
+	}
+	return cfgs, nil
+}
+
+// privateKey loads or generates the key of the actor.
+func (cfg ActorConfig) privateKey() (crypto.PrivateKey, error) {
+	if cfg.privKey != nil {
+		return cfg.privKey, nil
+	}
+	if len(cfg.KeyFile) > 0 {
+		return LoadOrGenerateKey(cfg.KeyFile, cfg.KeyBits)
+	}
+	return GenerateKey(cfg.KeyBits)
+}
+
+// localActor is one of the actors hosted by the report server, along with
+// the ids of its collections and its credentials.
+type localActor struct {
+	name         string
+	token        string
+	actorURL     *url.URL
+	keyURL       *url.URL
+	inboxURL     *url.URL
+	outboxURL    *url.URL
+	followingURL *url.URL
+	followersURL *url.URL
+	likedURL     *url.URL
+	pubKey       crypto.PublicKey
+	privKey      crypto.PrivateKey
+	keyPem       string
+	algo         httpsig.Algorithm
+}
+
+func newLocalActor(scheme, host string, cfg ActorConfig) (*localActor, error) {
+	if !actorNameRegexp.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid actor name %q", cfg.Name)
+	} else if len(cfg.Token) == 0 {
+		return nil, fmt.Errorf("actor %q has no token", cfg.Name)
+	}
+	privKey, err := cfg.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := publicKeyOf(privKey)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := keyAlgorithm(privKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPem, err := publicKeyPem(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s://%s%s%s", scheme, host, usersPath, cfg.Name)
+	l := &localActor{
+		name:    cfg.Name,
+		token:   cfg.Token,
+		pubKey:  pubKey,
+		privKey: privKey,
+		keyPem:  keyPem,
+		algo:    algo,
+	}
+	for _, u := range []struct {
+		dst  **url.URL
+		path string
+	}{
+		{&l.actorURL, ""},
+		{&l.keyURL, "#" + mainKeyFragment},
+		{&l.inboxURL, inboxPath},
+		{&l.outboxURL, outboxPath},
+		{&l.followingURL, followingPath},
+		{&l.followersURL, followersPath},
+		{&l.likedURL, likedPath},
+	} {
+		if *u.dst, err = url.Parse(base + u.path); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// collections returns the ids of all of the actor's collections.
+func (l *localActor) collections() []*url.URL {
+	return []*url.URL{l.inboxURL, l.outboxURL, l.followingURL, l.followersURL, l.likedURL}
+}
+
+// person builds the actor document, with endpoints pointing at authURL and
+// tokenURL.
+func (l *localActor) person(displayName string, authURL, tokenURL *url.URL) *keyedPerson {
+	if len(displayName) == 0 {
+		displayName = l.name
+	}
+	endpoints := &vocab.Object{}
+	endpoints.SetOauthAuthorizationEndpoint(authURL)
+	endpoints.SetOauthTokenEndpoint(tokenURL)
+	actor := &vocab.Person{}
+	actor.SetEndpoints(endpoints)
+	actor.SetId(l.actorURL)
+	actor.AppendNameString(displayName)
+	actor.SetOutboxAnyURI(l.outboxURL)
+	actor.SetInboxAnyURI(l.inboxURL)
+	actor.SetFollowingAnyURI(l.followingURL)
+	actor.SetFollowersAnyURI(l.followersURL)
+	actor.SetLikedAnyURI(l.likedURL)
+	// The name, unlike the display name, is usable in "acct:" URIs.
+	actor.SetPreferredUsername(l.name)
+	return l.withKey(actor)
+}
+
+// withKey wraps p so that it is served with the actor's publicKey.
+func (l *localActor) withKey(p *vocab.Person) *keyedPerson {
+	return &keyedPerson{Person: p, keyId: l.keyURL, keyPem: l.keyPem}
+}
+
+// actorOwnedProperties are the properties of an actor document set by the
+// server, rather than by Updates of the actor.
+var actorOwnedProperties = []string{"id", "type", "preferredUsername", "endpoints", "inbox", "outbox", "following", "followers", "liked"}
+
+// mergedPerson returns the stored document of the actor, if there is one, with
+// the properties the server sets taken from fresh, so that Updates of the
+// actor survive restarts.
+func (a *app) mergedPerson(c context.Context, l *localActor, fresh *keyedPerson) (*keyedPerson, error) {
+	if has, err := a.store.Has(c, l.actorURL); err != nil || !has {
+		return fresh, err
+	}
+	o, err := a.store.Get(c, l.actorURL, pub.Read)
+	if err != nil {
+		return nil, err
+	}
+	m, err := o.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	f, err := fresh.Person.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range actorOwnedProperties {
+		if v, ok := f[k]; ok {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+	p := &vocab.Person{}
+	if err := p.Deserialize(m); err != nil {
+		return nil, err
+	}
+	return l.withKey(p), nil
+}
+
+// addActor starts hosting the actor described by cfg, adding its document and
+// any of its collections the store does not have yet. A stored document keeps
+// what Updates changed in it.
+func (a *app) addActor(c context.Context, cfg ActorConfig) (*localActor, error) {
+	l, err := newLocalActor(a.scheme, a.host, cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.actorsMu.Lock()
+	defer a.actorsMu.Unlock()
+	if _, ok := a.actors[l.name]; ok {
+		return nil, fmt.Errorf("actor %q already exists", l.name)
+	}
+	for _, other := range a.actors {
+		if other.token == l.token {
+			return nil, fmt.Errorf("actor %q has the same token as %q", l.name, other.name)
+		}
+	}
+	p, err := a.mergedPerson(c, l, l.person(cfg.DisplayName, a.authURL, a.tokenURL))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.Put(c, p); err != nil {
+		return nil, err
+	}
+	for _, id := range l.collections() {
+		if has, err := a.store.Has(c, id); err != nil {
+			return nil, err
+		} else if has {
+			continue
+		}
+		oc := &vocab.OrderedCollection{}
+		oc.SetId(id)
+		if err := a.store.Put(c, oc); err != nil {
+			return nil, err
+		}
+	}
+	a.actors[l.name] = l
+	a.actorOrder = append(a.actorOrder, l.name)
+	return l, nil
+}
+
+// actorsSnapshot returns all hosted actors in the order they were added.
+func (a *app) actorsSnapshot() []*localActor {
+	a.actorsMu.RLock()
+	defer a.actorsMu.RUnlock()
+	actors := make([]*localActor, 0, len(a.actorOrder))
+	for _, name := range a.actorOrder {
+		actors = append(actors, a.actors[name])
+	}
+	return actors
+}
+
+// serveLegacyActor redirects requests to /actor and its collections to the
+// first actor, where the only actor used to be hosted. Other than GETs are
+// redirected with 308 so they are not turned into GETs.
+func (a *app) serveLegacyActor(w http.ResponseWriter, r *http.Request) {
+	l := a.firstActor()
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	u := *l.actorURL
+	u.Path += strings.TrimPrefix(r.URL.Path, legacyActorPath)
+	u.RawQuery = r.URL.RawQuery
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.String(), status)
+}
+
+// firstActor returns the actor hosted first, which acts on behalf of the
+// server as a whole, or nil if there is none.
+func (a *app) firstActor() *localActor {
+	a.actorsMu.RLock()
+	defer a.actorsMu.RUnlock()
+	if len(a.actorOrder) == 0 {
+		return nil
+	}
+	return a.actors[a.actorOrder[0]]
+}
+
+// findActor returns the first hosted actor for which match is true.
+func (a *app) findActor(match func(l *localActor) bool) (*localActor, bool) {
+	for _, l := range a.actorsSnapshot() {
+		if match(l) {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// actorFor returns the actor with the given id.
+func (a *app) actorFor(id *url.URL) (*localActor, bool) {
+	return a.findActor(func(l *localActor) bool {
+		return *id == *l.actorURL
+	})
+}
+
+// actorForBox returns the actor owning the inbox or outbox id.
+func (a *app) actorForBox(id *url.URL) (*localActor, bool) {
+	return a.findActor(func(l *localActor) bool {
+		return *id == *l.inboxURL || *id == *l.outboxURL
+	})
+}
+
+// actorForToken returns the actor authorized by a bearer token.
+func (a *app) actorForToken(token string) (*localActor, bool) {
+	return a.findActor(func(l *localActor) bool {
+		return l.token == token
+	})
+}
@@ -0,0 +1,45 @@
+package report
+
+import (
+	"context"
+	"github.com/go-fed/activity/vocab"
+	"testing"
+)
+
+func TestAddActorKeepsUpdates(t *testing.T) {
+	at := newAccessTest(t)
+	stored, ok := at.app.storedJSON(context.Background(), at.alice.actorURL.String())
+	if !ok {
+		t.Fatal("alice is not stored")
+	}
+	stored["name"] = "Alice Updated"
+	stored["summary"] = "updated"
+	p := &vocab.Person{}
+	if err := p.Deserialize(stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := at.app.Set(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart hosts alice again, with a new key.
+	restarted := newApp(at.app.scheme, at.app.host, at.app.newPath, at.app.store, at.app.authURL, at.app.tokenURL, at.app.verifier, at.app.client, at.app.clock, 0, defaultPageSize)
+	alice, err := restarted.addActor(context.Background(), ActorConfig{Name: "alice", Token: "alice-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := restarted.storedJSON(context.Background(), alice.actorURL.String())
+	if !ok {
+		t.Fatal("alice is not stored")
+	}
+	if stringValue(m["name"]) != "Alice Updated" || stringValue(m["summary"]) != "updated" {
+		t.Errorf("lost the Update: %v", m)
+	}
+	key, _ := m["publicKey"].(map[string]interface{})
+	if stringValue(key["publicKeyPem"]) != alice.keyPem {
+		t.Errorf("publishes %v, not the new key", key)
+	}
+	if stringValue(m["inbox"]) != alice.inboxURL.String() {
+		t.Errorf("inbox is %v", m["inbox"])
+	}
+}
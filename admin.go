@@ -0,0 +1,212 @@
+package report
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/vocab"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	adminActorsPath = "/admin/actors"
+)
+
+// requireAdminToken only lets requests bearing the admin token through to h.
+func requireAdminToken(token string, h http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeJSON responds with v encoded as JSON, with a JSON Content-Type unless
+// another one is already set.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	}
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// adminActor describes a hosted actor to administrators.
+type adminActor struct {
+	Name   string `json:"name"`
+	Id     string `json:"id"`
+	Inbox  string `json:"inbox"`
+	Outbox string `json:"outbox"`
+	KeyId  string `json:"keyId"`
+}
+
+func newAdminActor(l *localActor) adminActor {
+	return adminActor{
+		Name:   l.name,
+		Id:     l.actorURL.String(),
+		Inbox:  l.inboxURL.String(),
+		Outbox: l.outboxURL.String(),
+		KeyId:  l.keyURL.String(),
+	}
+}
+
+// createdActor is an actor created at /admin/actors as it is stored, with its
+// private key so that it keeps its key across restarts.
+type createdActor struct {
+	Name          string `json:"name"`
+	DisplayName   string `json:"displayName,omitempty"`
+	Token         string `json:"token"`
+	PrivateKeyPem string `json:"privateKeyPem"`
+}
+
+func (a *app) createdActorsURL() *url.URL {
+	return &url.URL{Scheme: a.scheme, Host: a.host, Path: adminActorsPath}
+}
+
+// createActor hosts the actor described by cfg, with a new key, and stores it
+// along with the actors created before. An actor hosted but not stored is
+// returned along with the error.
+func (a *app) createActor(c context.Context, cfg ActorConfig) (*localActor, error) {
+	var err error
+	if cfg.privKey, err = GenerateKey(cfg.KeyBits); err != nil {
+		return nil, err
+	}
+	keyPem, err := privateKeyPem(cfg.privKey)
+	if err != nil {
+		return nil, err
+	}
+	l, err := a.addActor(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.actorsMu.Lock()
+	a.created = append(a.created, createdActor{
+		Name:          cfg.Name,
+		DisplayName:   cfg.DisplayName,
+		Token:         cfg.Token,
+		PrivateKeyPem: string(keyPem),
+	})
+	created := append([]createdActor{}, a.created...)
+	a.actorsMu.Unlock()
+	return l, a.saveCreatedActors(c, created)
+}
+
+// saveCreatedActors stores the created actors as a collection, so they survive
+// restarts with a durable Store.
+func (a *app) saveCreatedActors(c context.Context, created []createdActor) error {
+	items := make([]interface{}, 0, len(created))
+	for _, ca := range created {
+		b, err := json.Marshal(ca)
+		if err != nil {
+			return err
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		items = append(items, m)
+	}
+	oc := &vocab.OrderedCollection{}
+	if err := oc.Deserialize(map[string]interface{}{
+		"id":           a.createdActorsURL().String(),
+		"type":         "OrderedCollection",
+		"orderedItems": items,
+	}); err != nil {
+		return err
+	}
+	return a.store.Put(c, oc)
+}
+
+// restoreCreatedActors hosts the actors stored by saveCreatedActors again.
+func (a *app) restoreCreatedActors(c context.Context) error {
+	id := a.createdActorsURL()
+	if has, err := a.store.Has(c, id); err != nil || !has {
+		return err
+	}
+	oc, err := a.getCollection(c, id)
+	if err != nil {
+		return err
+	}
+	m, err := oc.Serialize()
+	if err != nil {
+		return err
+	}
+	restored := 0
+	for _, item := range orderedItems(m) {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		var ca createdActor
+		if err := json.Unmarshal(b, &ca); err != nil {
+			return fmt.Errorf("reading created actor: %s", err)
+		}
+		privKey, err := parsePrivateKey([]byte(ca.PrivateKeyPem))
+		if err != nil {
+			return fmt.Errorf("reading key of created actor %q: %s", ca.Name, err)
+		}
+		cfg := ActorConfig{Name: ca.Name, DisplayName: ca.DisplayName, Token: ca.Token, privKey: privKey}
+		if _, err := a.addActor(c, cfg); err != nil {
+			log.Printf("cannot restore created actor %q: %s", ca.Name, err)
+			continue
+		}
+		a.actorsMu.Lock()
+		a.created = append(a.created, ca)
+		a.actorsMu.Unlock()
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("restored %d created actors", restored)
+	}
+	return nil
+}
+
+// serveAdminActors lists the hosted actors on GET, and creates an actor from a
+// JSON encoded ActorConfig on POST. Created actors are kept in the store with
+// their keys, and hosted again on restart.
+func (a *app) serveAdminActors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var actors []adminActor
+		for _, l := range a.actorsSnapshot() {
+			actors = append(actors, newAdminActor(l))
+		}
+		writeJSON(w, http.StatusOK, actors)
+	case http.MethodPost:
+		var cfg ActorConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if len(cfg.KeyFile) > 0 {
+			// Do not let requests write to arbitrary files.
+			http.Error(w, "keyFile is not allowed here", http.StatusBadRequest)
+			return
+		}
+		l, err := a.createActor(context.Background(), cfg)
+		if l == nil {
+			http.Error(w, fmt.Sprintf("cannot create actor: %s", err), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("cannot store created actor %s: %s", l.actorURL, err)
+			http.Error(w, "created the actor, but cannot store it", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("created actor %s", l.actorURL)
+		w.Header().Set("Location", l.actorURL.String())
+		writeJSON(w, http.StatusCreated, newAdminActor(l))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,77 @@
+package report
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLegacyActorRedirects(t *testing.T) {
+	at := newAccessTest(t)
+	alice := at.alice.actorURL.String()
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+		want   string
+	}{
+		{http.MethodGet, "/actor", http.StatusMovedPermanently, alice},
+		{http.MethodGet, "/actor/outbox?page=2", http.StatusMovedPermanently, alice + "/outbox?page=2"},
+		{http.MethodPost, "/actor/inbox", http.StatusPermanentRedirect, alice + "/inbox"},
+	} {
+		rec := httptest.NewRecorder()
+		at.app.serveLegacyActor(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status || rec.Header().Get("Location") != test.want {
+			t.Errorf("%s %s: got %d to %s, want %d to %s", test.method, test.path, rec.Code, rec.Header().Get("Location"), test.status, test.want)
+		}
+	}
+}
+
+func TestAdminActorsRestored(t *testing.T) {
+	at := newAccessTest(t)
+	dir := t.TempDir()
+	var err error
+	if at.app.store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	// restart reads the store from disk again, hosting only the created
+	// actors.
+	restart := func() *app {
+		s, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := newApp(at.app.scheme, at.app.host, at.app.newPath, s, at.app.authURL, at.app.tokenURL, at.app.verifier, at.app.client, at.app.clock, 0, defaultPageSize)
+		if err := a.restoreCreatedActors(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"name": "dave", "displayName": "Dave", "token": "dave-token"}`)
+	at.app.serveAdminActors(rec, httptest.NewRequest(http.MethodPost, adminActorsPath, body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	dave, _ := at.app.actorForToken("dave-token")
+
+	restarted := restart()
+	l, ok := restarted.actorForToken("dave-token")
+	if !ok || l.name != "dave" || *l.actorURL != *dave.actorURL {
+		t.Fatalf("restored %v", restarted.actorsSnapshot())
+	} else if l.keyPem != dave.keyPem {
+		t.Error("restored with another key")
+	}
+	// It is stored once more when another actor is created.
+	body = strings.NewReader(`{"name": "erin", "token": "erin-token"}`)
+	rec = httptest.NewRecorder()
+	restarted.serveAdminActors(rec, httptest.NewRequest(http.MethodPost, adminActorsPath, body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if n := len(restart().actorsSnapshot()); n != 2 {
+		t.Errorf("restored %d actors, want 2", n)
+	}
+}
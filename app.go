@@ -1,17 +1,21 @@
 package report
 
 import (
+	"bytes"
 	"context"
 	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/go-fed/activity/pub"
-	"github.com/go-fed/activity/streams"
 	"github.com/go-fed/activity/vocab"
 	"github.com/go-fed/httpsig"
 	"log"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 )
 
 var _ pub.Application = &app{}
@@ -21,85 +25,106 @@ var _ pub.SocialApplication = &app{}
 var _ pub.FederateApplication = &app{}
 var _ pub.SocialFederateApplication = &app{}
 
-type lockKeyType string
+// app shows the basic mechanics for a multi-user dummy server. Objects are
+// kept in a Store, which is non-permanent by default.
+type app struct {
+	scheme     string
+	host       string
+	newPath    string
+	store      Store
+	authURL    *url.URL
+	tokenURL   *url.URL
+	actors     map[string]*localActor
+	actorOrder []string
+	actorsMu   *sync.RWMutex
+	keys       *publicKeyCache
+	verifier   pub.SocialAPIVerifier
+	client     pub.HttpClient
+	clock      pub.Clock
+	pageSize   int
+	// signatureMode and clockSkew control the verification of signatures
+	// on inbox POSTs.
+	signatureMode string
+	clockSkew     time.Duration
+	// followPolicy decides what becomes of Follows, and follows holds
+	// those awaiting a decision under FollowsManual.
+	followPolicy string
+	follows      *followQueue
+	// deliverer sends the activities the app makes itself.
+	deliverer pub.Deliverer
+	blocks    *blocklist
+	// forwarding limits inbox forwarding, unless permissiveForwarding
+	// forwards everything.
+	forwarding           *forwardingLimiter
+	permissiveForwarding bool
+	// activityVersion is the version of go-fed/activity reported in
+	// NodeInfo.
+	activityVersion string
+	// created are the actors created at /admin/actors, as they are
+	// stored. It is guarded by actorsMu.
+	created []createdActor
+}
 
-type lockObj struct {
-	obj pub.PubObject
-	mu  *sync.RWMutex
-	who int
+// newApp prepares an app backed by store, hosting no actors yet.
+func newApp(scheme, host, newPath string, store Store, authURL, tokenURL *url.URL, verifier pub.SocialAPIVerifier, client pub.HttpClient, clock pub.Clock, keyTTL time.Duration, pageSize int) *app {
+	a := &app{
+		scheme:   scheme,
+		host:     host,
+		newPath:  newPath,
+		store:    store,
+		authURL:  authURL,
+		tokenURL: tokenURL,
+		actors:   make(map[string]*localActor),
+		actorsMu: &sync.RWMutex{},
+		verifier: verifier,
+		client:   client,
+		clock:    clock,
+		pageSize: pageSize,
+		// Set by SetReportMux.
+		signatureMode: SignaturesOff,
+		clockSkew:     defaultClockSkew,
+		followPolicy:  FollowsAccept,
+		follows:       newFollowQueue(),
+		blocks:        newBlocklist(nil),
+		forwarding:    newForwardingLimiter(defaultForwardingLimit, forwardingWindow),
+	}
+	a.keys = newPublicKeyCache(client, clock, keyTTL, a.sign)
+	return a
 }
 
-// app shows the basic mechanics for a single-user, non-permanent, dummy server.
-type app struct {
-	scheme       string
-	host         string
-	newPath      string
-	db           map[string]*lockObj
-	dbMu         *sync.RWMutex
-	actor        *vocab.Person
-	actorURL     *url.URL
-	inboxURL     *url.URL
-	outboxURL    *url.URL
-	followingURL *url.URL
-	followersURL *url.URL
-	likedURL     *url.URL
-	inbox        vocab.OrderedCollectionType
-	inboxMu      *sync.RWMutex
-	outbox       vocab.OrderedCollectionType
-	outboxMu     *sync.RWMutex
-	following    vocab.OrderedCollectionType
-	followingMu  *sync.RWMutex
-	followers    vocab.OrderedCollectionType
-	followersMu  *sync.RWMutex
-	liked        vocab.OrderedCollectionType
-	likedMu      *sync.RWMutex
-	id           int
-	idMu         *sync.Mutex
-	pubKey       crypto.PublicKey
-	privKey      crypto.PrivateKey
-	verifier     pub.SocialAPIVerifier
+// isActor determines whether id is one of the hosted actors.
+func (a *app) isActor(id *url.URL) bool {
+	_, ok := a.actorFor(id)
+	return ok
 }
 
-func newApp(scheme, host, newPath string, actorURL, inboxURL, outboxURL, followingURL, followersURL, likedURL *url.URL, pubKey crypto.PublicKey, privKey crypto.PrivateKey, actor *vocab.Person, verifier pub.SocialAPIVerifier) *app {
-	inbox := &vocab.OrderedCollection{}
-	inbox.SetId(inboxURL)
-	outbox := &vocab.OrderedCollection{}
-	outbox.SetId(outboxURL)
-	following := &vocab.OrderedCollection{}
-	following.SetId(followingURL)
-	followers := &vocab.OrderedCollection{}
-	followers.SetId(followersURL)
-	liked := &vocab.OrderedCollection{}
-	liked.SetId(likedURL)
-	return &app{
-		scheme:       scheme,
-		host:         host,
-		newPath:      newPath,
-		db:           make(map[string]*lockObj),
-		dbMu:         &sync.RWMutex{},
-		actor:        actor,
-		actorURL:     actorURL,
-		inboxURL:     inboxURL,
-		outboxURL:    outboxURL,
-		followingURL: followingURL,
-		followersURL: followersURL,
-		likedURL:     likedURL,
-		inbox:        inbox,
-		inboxMu:      &sync.RWMutex{},
-		outbox:       outbox,
-		outboxMu:     &sync.RWMutex{},
-		following:    following,
-		followingMu:  &sync.RWMutex{},
-		followers:    followers,
-		followersMu:  &sync.RWMutex{},
-		liked:        liked,
-		likedMu:      &sync.RWMutex{},
-		id:           1,
-		idMu:         &sync.Mutex{},
-		pubKey:       pubKey,
-		privKey:      privKey,
-		verifier:     verifier,
+// isCollection determines whether id is one of the collections of an actor.
+func (a *app) isCollection(id *url.URL) bool {
+	_, ok := a.findActor(func(l *localActor) bool {
+		for _, cid := range l.collections() {
+			if *id == *cid {
+				return true
+			}
+		}
+		return false
+	})
+	return ok
+}
+
+// getCollection fetches one of the collections of an actor from the store.
+//
+// Collections are only ever replaced wholesale by Set, so they are never held
+// locked for the duration of a request.
+func (a *app) getCollection(c context.Context, id *url.URL) (vocab.OrderedCollectionType, error) {
+	o, err := a.store.Get(c, id, pub.Read)
+	if err != nil {
+		return nil, err
+	}
+	oc, ok := o.(vocab.OrderedCollectionType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an OrderedCollectionType", id)
 	}
+	return oc, nil
 }
 
 func (a *app) Owns(c context.Context, id *url.URL) bool {
@@ -107,183 +132,85 @@ func (a *app) Owns(c context.Context, id *url.URL) bool {
 	return id.Host == a.host
 }
 
+// resolveKey maps the id of an actor's public key to the actor document
+// holding it.
+func (a *app) resolveKey(id *url.URL) *url.URL {
+	if l, ok := a.findActor(func(l *localActor) bool { return *id == *l.keyURL }); ok {
+		return l.actorURL
+	}
+	return id
+}
+
 func (a *app) Get(c context.Context, id *url.URL, rw pub.RWType) (pub.PubObject, error) {
 	log.Printf("Getting: %s", id)
-	has, err := a.Has(c, id)
+	id = a.resolveKey(id)
+	if a.isActor(id) || a.isCollection(id) {
+		rw = pub.Read
+	}
+	return a.store.Get(c, id, rw)
+}
+
+func (a *app) GetAsVerifiedUser(c context.Context, id, authdUser *url.URL, rw pub.RWType) (pub.PubObject, error) {
+	log.Printf("GetAsVerifiedUser: %s as %s", id, authdUser)
+	o, err := a.Get(c, id, rw)
 	if err != nil {
 		return nil, err
-	} else if !has {
-		return nil, fmt.Errorf("%s not found", id)
 	}
-	if *id == *a.actorURL {
-		return a.actor, nil
-	} else if *id == *a.inboxURL {
-		a.inboxMu.RLock()
-		defer a.inboxMu.RUnlock()
-		return a.inbox, nil
-	} else if *id == *a.outboxURL {
-		a.outboxMu.RLock()
-		defer a.outboxMu.RUnlock()
-		return a.outbox, nil
-	} else if *id == *a.followingURL {
-		a.followingMu.RLock()
-		defer a.followingMu.RUnlock()
-		return a.following, nil
-	} else if *id == *a.followersURL {
-		a.followersMu.RLock()
-		defer a.followersMu.RUnlock()
-		return a.followers, nil
-	} else if *id == *a.likedURL {
-		a.likedMu.RLock()
-		defer a.likedMu.RUnlock()
-		return a.liked, nil
+	id = a.resolveKey(id)
+	if a.isActor(id) || a.isCollection(id) {
+		return o, nil
 	}
-	a.dbMu.RLock()
-	p, ok := a.db[id.String()]
-	a.dbMu.RUnlock()
-	if !ok {
-		return nil, fmt.Errorf("%s not found", id)
-	}
-	switch rw {
-	case pub.Read:
-		p.mu.RLock()
-		defer p.mu.RUnlock()
-	case pub.ReadWrite:
-		who := c.Value(lockKeyType("lockKey")).(int)
-		if p.who != who {
-			log.Printf("locking %s", id)
-			p.mu.Lock()
-			p.who = who
-			go func() {
-				<-c.Done()
-				if p.who == who {
-					log.Printf("unlocking %s", id)
-					p.mu.Unlock()
-				}
-			}()
-		}
-	default:
-		return nil, fmt.Errorf("unrecognized pub.RWType: %v", rw)
+	if err := a.checkVisible(c, o, authdUser); err != nil {
+		return nil, err
 	}
-	return p.obj, nil
-}
-
-func (a *app) GetAsVerifiedUser(c context.Context, id, authdUser *url.URL, rw pub.RWType) (pub.PubObject, error) {
-	log.Printf("GetAsVerifiedUser: %s", id)
-	return a.Get(c, id, rw)
+	return o, nil
 }
 
 func (a *app) Has(c context.Context, id *url.URL) (bool, error) {
 	log.Printf("Has: %s", id)
-	if *id == *a.actorURL || *id == *a.inboxURL || *id == *a.outboxURL || *id == *a.followingURL || *id == *a.followersURL || *id == *a.likedURL {
-		return true, nil
-	}
-	_, ok := a.db[id.String()]
-	return ok, nil
+	return a.store.Has(c, a.resolveKey(id))
 }
 
 func (a *app) Set(c context.Context, o pub.PubObject) error {
 	b, _ := o.Serialize()
 	log.Printf("Setting: %s", b)
-	if id := o.GetId(); id == nil {
+	id := o.GetId()
+	if id == nil {
 		return fmt.Errorf("id is nil")
-	} else if *id == *a.outboxURL {
-		a.outboxMu.Lock()
-		defer a.outboxMu.Unlock()
-		oc, ok := o.(vocab.OrderedCollectionType)
-		if !ok {
-			return fmt.Errorf("setting %s but not an OrderedCollectionType", id)
-		}
-		a.outbox = oc
-		return nil
-	} else if *id == *a.inboxURL {
-		a.inboxMu.Lock()
-		defer a.inboxMu.Unlock()
-		oc, ok := o.(vocab.OrderedCollectionType)
-		if !ok {
-			return fmt.Errorf("setting %s but not an OrderedCollectionType", id)
-		}
-		a.inbox = oc
-		return nil
-	} else if *id == *a.followingURL {
-		a.followingMu.Lock()
-		defer a.followingMu.Unlock()
-		oc, ok := o.(vocab.OrderedCollectionType)
-		if !ok {
-			return fmt.Errorf("setting %s but not an OrderedCollectionType", id)
-		}
-		a.following = oc
-		return nil
-	} else if *id == *a.followersURL {
-		a.followersMu.Lock()
-		defer a.followersMu.Unlock()
-		oc, ok := o.(vocab.OrderedCollectionType)
-		if !ok {
-			return fmt.Errorf("setting %s but not an OrderedCollectionType", id)
-		}
-		a.followers = oc
-		return nil
-	} else if *id == *a.likedURL {
-		a.likedMu.Lock()
-		defer a.likedMu.Unlock()
-		oc, ok := o.(vocab.OrderedCollectionType)
-		if !ok {
+	} else if a.isCollection(id) {
+		if _, ok := o.(vocab.OrderedCollectionType); !ok {
 			return fmt.Errorf("setting %s but not an OrderedCollectionType", id)
 		}
-		a.liked = oc
-		return nil
-	} else {
-		a.dbMu.Lock()
-		if v, ok := a.db[id.String()]; ok {
-			a.dbMu.Unlock()
-			who := c.Value(lockKeyType("lockKey")).(int)
-			vWho := v.who
-			// TODO: Use sync.Cond
-			if vWho == 0 || vWho != who {
-				log.Printf("locking %s", id)
-				v.mu.Lock()
-				v.who = who
-			}
-			v.obj = o
-			v.who = 0
-			log.Printf("unlocking %s", id)
-			v.mu.Unlock()
-		} else {
-			a.db[id.String()] = &lockObj{
-				obj: o,
-				mu:  &sync.RWMutex{},
-			}
-			a.dbMu.Unlock()
+	} else if p, ok := o.(*vocab.Person); ok {
+		// Keep publishing the key when an actor is updated.
+		if l, ok := a.actorFor(id); ok {
+			o = l.withKey(p)
 		}
-		return nil
 	}
+	return a.store.Put(c, o)
 }
 
 func (a *app) GetInbox(c context.Context, r *http.Request, rw pub.RWType) (vocab.OrderedCollectionType, error) {
 	log.Printf("GetInbox: %s", r.URL)
-	if *r.URL == *a.inboxURL {
-		a.inboxMu.RLock()
-		defer a.inboxMu.RUnlock()
-		return a.inbox, nil
+	if l, ok := a.actorForBox(r.URL); ok && *r.URL == *l.inboxURL {
+		return a.getCollection(c, l.inboxURL)
 	}
 	return nil, fmt.Errorf("no inbox for url %s", r.URL)
 }
 
 func (a *app) GetOutbox(c context.Context, r *http.Request, rw pub.RWType) (vocab.OrderedCollectionType, error) {
 	log.Printf("GetOutbox: %s", r.URL)
-	if *r.URL == *a.outboxURL {
-		a.outboxMu.RLock()
-		defer a.outboxMu.RUnlock()
-		return a.outbox, nil
+	if l, ok := a.actorForBox(r.URL); ok && *r.URL == *l.outboxURL {
+		return a.getCollection(c, l.outboxURL)
 	}
 	return nil, fmt.Errorf("no outbox for url %s", r.URL)
 }
 
 func (a *app) NewId(c context.Context, t pub.Typer) *url.URL {
-	a.idMu.Lock()
-	id := a.id
-	a.id++
-	a.idMu.Unlock()
+	id, err := a.store.NextId(c)
+	if err != nil {
+		log.Print(err)
+	}
 	withoutTrailingSlash := a.newPath
 	if a.newPath[len(a.newPath)-1] == '/' {
 		withoutTrailingSlash = a.newPath[:len(a.newPath)-1]
@@ -296,7 +223,15 @@ func (a *app) NewId(c context.Context, t pub.Typer) *url.URL {
 }
 
 func (a *app) GetPublicKey(c context.Context, publicKeyId string) (pubKey crypto.PublicKey, algo httpsig.Algorithm, user *url.URL, err error) {
-	return nil, httpsig.RSA_SHA256, nil, fmt.Errorf("not implemented: GetPublicKey")
+	log.Printf("GetPublicKey: %s", publicKeyId)
+	if l, ok := a.findActor(func(l *localActor) bool { return publicKeyId == l.keyURL.String() }); ok {
+		return l.pubKey, l.algo, l.actorURL, nil
+	}
+	k, err := a.keys.Get(c, publicKeyId)
+	if err != nil {
+		return nil, httpsig.RSA_SHA256, nil, err
+	}
+	return k.pubKey, k.algo, k.owner, nil
 }
 
 func (a *app) CanAdd(c context.Context, o vocab.ObjectType, t vocab.ObjectType) bool {
@@ -309,8 +244,8 @@ func (a *app) CanRemove(c context.Context, o vocab.ObjectType, t vocab.ObjectTyp
 
 func (a *app) ActorIRI(c context.Context, r *http.Request) (*url.URL, error) {
 	log.Printf("ActorIRI: %s", r.URL)
-	if *r.URL == *a.inboxURL || *r.URL == *a.outboxURL {
-		return a.actorURL, nil
+	if l, ok := a.actorForBox(r.URL); ok {
+		return l.actorURL, nil
 	}
 	return nil, fmt.Errorf("no actor for url %s", r.URL)
 }
@@ -320,33 +255,83 @@ func (a *app) GetSocialAPIVerifier(c context.Context) pub.SocialAPIVerifier {
 }
 
 func (a *app) GetPublicKeyForOutbox(c context.Context, publicKeyId string, boxIRI *url.URL) (crypto.PublicKey, httpsig.Algorithm, error) {
-	if boxIRI != a.outboxURL {
+	l, ok := a.actorForBox(boxIRI)
+	if !ok || *boxIRI != *l.outboxURL {
 		return nil, httpsig.RSA_SHA256, fmt.Errorf("unknown outbox url %s", boxIRI)
-	} else if publicKeyId != a.actorURL.String() {
-		return nil, httpsig.RSA_SHA256, fmt.Errorf("unknown public key id %q", publicKeyId)
+	} else if publicKeyId != l.keyURL.String() {
+		return nil, l.algo, fmt.Errorf("unknown public key id %q", publicKeyId)
 	}
-	return a.pubKey, httpsig.RSA_SHA256, nil
+	return l.pubKey, l.algo, nil
 }
 
-func (a *app) OnFollow(c context.Context, s *streams.Follow) pub.FollowResponse {
-	return pub.AutomaticAccept
-}
-
-func (a *app) Unblocked(c context.Context, actorIRIs []*url.URL) error {
-	return nil
+func (a *app) NewSigner() (httpsig.Signer, error) {
+	// Actors may have keys of different kinds, and which actor signs is
+	// only known once PrivateKey is called.
+	return &keyTypeSigner{}, nil
 }
 
-func (a *app) FilterForwarding(c context.Context, activity vocab.ActivityType, iris []*url.URL) ([]*url.URL, error) {
-	// Do NOT do this in real implementations. This turns the server into a
-	// spambot. See the documentation in go-fed/activity/pub.
-	return iris, nil
+func (a *app) PrivateKey(boxIRI *url.URL) (privKey crypto.PrivateKey, pubKeyId string, err error) {
+	l, ok := a.actorForBox(boxIRI)
+	if !ok {
+		return nil, "", fmt.Errorf("no actor for box %s", boxIRI)
+	}
+	return l.privKey, l.keyURL.String(), nil
 }
 
-func (a *app) NewSigner() (httpsig.Signer, error) {
-	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, nil, httpsig.Signature)
-	return s, err
+// sign signs a request made on behalf of the server, such as fetching the key
+// of a peer that requires authorized fetches, using the key of the first
+// actor.
+func (a *app) sign(r *http.Request) error {
+	l := a.firstActor()
+	if l == nil {
+		return fmt.Errorf("no actor to sign with")
+	}
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{l.algo}, []string{"(request-target)", "host", "date"}, httpsig.Signature)
+	if err != nil {
+		return err
+	}
+	return s.SignRequest(l.privKey, l.keyURL.String(), r)
 }
 
-func (a *app) PrivateKey(boxIRI *url.URL) (privKey crypto.PrivateKey, pubKeyId string, err error) {
-	return a.privKey, a.actorURL.String(), nil
+// deliver POSTs the activity b to the inbox to, signed by the key of the
+// activity's actor. It does what the library does for deliveries that were
+// queued on disk before a restart, when the library's function is gone.
+func (a *app) deliver(b []byte, to *url.URL) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	actor, err := url.Parse(stringValue(m["actor"]))
+	if err != nil {
+		return err
+	}
+	l, ok := a.actorFor(actor)
+	if !ok {
+		return fmt.Errorf("cannot deliver for actor %q that is not hosted here", actor)
+	}
+	req, err := http.NewRequest(http.MethodPost, to.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(b)
+	req.Header.Set("Content-Type", activityJSONType)
+	req.Header.Set("Date", a.clock.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", to.Host)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{l.algo}, []string{"(request-target)", "host", "date", "digest"}, httpsig.Signature)
+	if err != nil {
+		return err
+	}
+	if err := s.SignRequest(l.privKey, l.keyURL.String(), req); err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("delivering to %s: %s", to, resp.Status)
+	}
+	return nil
 }
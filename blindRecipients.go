@@ -0,0 +1,112 @@
+package report
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// blindRecipientProperties are kept in the store so activities are still
+// delivered to blind recipients, but never served.
+var blindRecipientProperties = []string{"bto", "bcc"}
+
+// stripBlindRecipients removes the blind recipients of v and of every object
+// embedded in it, and reports whether there were any.
+func stripBlindRecipients(v interface{}) bool {
+	stripped := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, p := range blindRecipientProperties {
+			if _, ok := t[p]; ok {
+				delete(t, p)
+				stripped = true
+			}
+		}
+		for _, e := range t {
+			if stripBlindRecipients(e) {
+				stripped = true
+			}
+		}
+	case []interface{}:
+		for _, e := range t {
+			if stripBlindRecipients(e) {
+				stripped = true
+			}
+		}
+	}
+	return stripped
+}
+
+// blindRecipientsFilter buffers a JSON response so its blind recipients can be
+// stripped before it is written to w, whoever made it: go-fed/activity serves
+// stored objects and boxes as they are.
+type blindRecipientsFilter struct {
+	w      http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// filterBlindRecipients returns the ResponseWriter to serve r with, and a
+// function to call once it is served. Only GETs are filtered.
+func filterBlindRecipients(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if r.Method != http.MethodGet {
+		return w, func() {}
+	}
+	f := &blindRecipientsFilter{w: w}
+	return f, f.flush
+}
+
+func (f *blindRecipientsFilter) Header() http.Header {
+	return f.w.Header()
+}
+
+func (f *blindRecipientsFilter) WriteHeader(status int) {
+	if f.status == 0 {
+		f.status = status
+	}
+}
+
+func (f *blindRecipientsFilter) Write(b []byte) (int, error) {
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	return f.body.Write(b)
+}
+
+// flush writes the response, without its blind recipients if it is JSON.
+func (f *blindRecipientsFilter) flush() {
+	if f.status == 0 {
+		return
+	}
+	b := f.body.Bytes()
+	if strings.Contains(f.w.Header().Get("Content-Type"), "json") {
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err == nil && stripBlindRecipients(v) {
+			if s, err := json.Marshal(v); err != nil {
+				log.Printf("cannot strip blind recipients: %s", err)
+				b = nil
+				f.status = http.StatusInternalServerError
+			} else {
+				b = s
+			}
+			if len(f.w.Header().Get("Content-Length")) > 0 {
+				f.w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			}
+			// The digest of the original body would be wrong, and
+			// would give away the stripped recipients.
+			if len(f.w.Header().Get("Digest")) > 0 {
+				h := sha256.Sum256(b)
+				f.w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(h[:]))
+			}
+		}
+	}
+	f.w.WriteHeader(f.status)
+	if _, err := f.w.Write(b); err != nil {
+		log.Printf("cannot write response: %s", err)
+	}
+}
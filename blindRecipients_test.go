@@ -0,0 +1,61 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBlindRecipientsStripped(t *testing.T) {
+	original := `{"id":"a","bcc":["x"],"orderedItems":[{"bto":"y","object":{"bcc":"z","content":"hi"}}]}`
+	h := sha256.Sum256([]byte(original))
+	rec := httptest.NewRecorder()
+	w, flush := filterBlindRecipients(rec, httptest.NewRequest(http.MethodGet, "/new/1", nil))
+	w.Header().Set("Content-Type", activityJSONType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(original)))
+	w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(h[:]))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(original))
+	flush()
+
+	body := rec.Body.String()
+	if want := `{"id":"a","orderedItems":[{"object":{"content":"hi"}}]}`; body != want {
+		t.Errorf("got %s, want %s", body, want)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length is %s for %d bytes", got, len(body))
+	}
+	h = sha256.Sum256([]byte(body))
+	if got, want := rec.Header().Get("Digest"), "SHA-256="+base64.StdEncoding.EncodeToString(h[:]); got != want {
+		t.Errorf("Digest is %s, want %s", got, want)
+	}
+}
+
+func TestBlindRecipientsUntouched(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+	}{
+		{"no blind recipients", http.MethodGet, activityJSONType, `{"to": ["x"], "id": "a"}`},
+		{"not JSON", http.MethodGet, "text/plain", `{"bcc": ["x"]}`},
+		{"not a GET", http.MethodPost, activityJSONType, `{"bcc": ["x"]}`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w, flush := filterBlindRecipients(rec, httptest.NewRequest(test.method, "/new/1", strings.NewReader("")))
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(test.body))
+			flush()
+			if rec.Code != http.StatusCreated || rec.Body.String() != test.body {
+				t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body, http.StatusCreated, test.body)
+			}
+		})
+	}
+}
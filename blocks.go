@@ -0,0 +1,444 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/vocab"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	adminBlocksPath = "/admin/blocks"
+	domainParam     = "domain"
+)
+
+var _ pub.Callbacker = &blockingCallbacker{}
+var _ pub.Deliverer = &blockingDeliverer{}
+
+// block is an actor blocked by a Block sent from the outbox of a hosted
+// actor.
+type block struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	// Inbox is the inbox of Actor, if it could be fetched, so deliveries to
+	// it are skipped.
+	Inbox     string `json:"inbox,omitempty"`
+	BlockedBy string `json:"blockedBy"`
+	// Block is the id of the Block activity, which an Undo refers to.
+	Block string `json:"block"`
+}
+
+// blocklist holds the actors blocked by the hosted actors, and the domains
+// blocked by administrators. Incoming activities from either are refused, and
+// nothing is delivered to them.
+type blocklist struct {
+	blocks  []block
+	domains map[string]bool
+	// save, if set, is given the blocked domains whenever administrators
+	// change them.
+	save func(domains []string)
+	mu   *sync.Mutex
+}
+
+func newBlocklist(domains []string) *blocklist {
+	b := &blocklist{
+		domains: make(map[string]bool),
+		mu:      &sync.Mutex{},
+	}
+	for _, d := range domains {
+		if d = strings.TrimSpace(d); len(d) > 0 {
+			b.addDomain(d)
+		}
+	}
+	return b
+}
+
+func (b *blocklist) addBlock(bl block) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blocks = append(b.blocks, bl)
+}
+
+// removeBlock forgets the actors blocked by the Block with the given id, if
+// it was made by the actor undoing it.
+func (b *blocklist) removeBlock(id, by string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	removed := false
+	kept := b.blocks[:0]
+	for _, bl := range b.blocks {
+		if bl.Block == id && bl.BlockedBy == by {
+			removed = true
+		} else {
+			kept = append(kept, bl)
+		}
+	}
+	b.blocks = kept
+	return removed
+}
+
+// setInbox notes the inbox of the actor blocked by the Block with the given
+// id.
+func (b *blocklist) setInbox(id, actor, inbox string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.blocks {
+		if b.blocks[i].Block == id && b.blocks[i].Actor == actor {
+			b.blocks[i].Inbox = inbox
+		}
+	}
+}
+
+// domainList returns the blocked domains in order.
+func (b *blocklist) domainList() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	domains := []string{}
+	for d := range b.domains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// changed saves the blocked domains after administrators changed them.
+func (b *blocklist) changed() {
+	if b.save != nil {
+		b.save(b.domainList())
+	}
+}
+
+func (b *blocklist) addDomain(domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domains[strings.ToLower(domain)] = true
+}
+
+func (b *blocklist) removeDomain(domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.domains, strings.ToLower(domain))
+}
+
+// domainBlocked determines whether host is a blocked domain or one of its
+// subdomains. It must be called with mu held.
+func (b *blocklist) domainBlocked(host string) bool {
+	host = strings.ToLower(host)
+	for d := range b.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocked determines whether the actor or inbox u is blocked, either itself
+// or by its domain.
+func (b *blocklist) blocked(u *url.URL) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.domainBlocked(u.Hostname()) {
+		return true
+	}
+	s := u.String()
+	for _, bl := range b.blocks {
+		if bl.Actor == s || bl.Inbox == s {
+			return true
+		}
+	}
+	return false
+}
+
+// collection returns the blocklist as an OrderedCollection of the blocked
+// actors, with the blocked domains alongside.
+func (b *blocklist) collection(id string) map[string]interface{} {
+	domains := b.domainList()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool)
+	items := []interface{}{}
+	for _, bl := range b.blocks {
+		if !seen[bl.Actor] {
+			seen[bl.Actor] = true
+			items = append(items, bl.Actor)
+		}
+	}
+	return map[string]interface{}{
+		"@context":       activityStreamsContext,
+		"id":             id,
+		"type":           "OrderedCollection",
+		"totalItems":     len(items),
+		"orderedItems":   items,
+		"blockedDomains": domains,
+	}
+}
+
+// blocksOf returns the blocks made by the serialized Block m at t, without
+// the inboxes of the blocked actors.
+func blocksOf(m map[string]interface{}, t time.Time) []block {
+	var bs []block
+	for _, actor := range ids(m["object"]) {
+		bs = append(bs, block{
+			Time:      t,
+			Actor:     actor,
+			BlockedBy: actorId(m["actor"]),
+			Block:     stringValue(m["id"]),
+		})
+	}
+	return bs
+}
+
+// restoreBlocks rebuilds the blocklist from the Blocks in the outboxes of the
+// hosted actors that were not undone, as the blocklist itself is not stored.
+// The inboxes of the blocked actors are then fetched in the background.
+func (a *app) restoreBlocks(c context.Context) error {
+	var blocks []map[string]interface{}
+	// undone holds the ids of the Blocks undone by each actor.
+	undone := make(map[string]map[string]bool)
+	for _, l := range a.actorsSnapshot() {
+		oc, err := a.getCollection(c, l.outboxURL)
+		if err != nil {
+			return err
+		}
+		m, err := oc.Serialize()
+		if err != nil {
+			return err
+		}
+		for _, item := range orderedItems(m) {
+			am, ok := item.(map[string]interface{})
+			if !ok {
+				if am, ok = a.storedJSON(c, stringValue(item)); !ok {
+					continue
+				}
+			}
+			for _, t := range typeNames(am["type"]) {
+				if t == "Block" {
+					blocks = append(blocks, am)
+				} else if t == "Undo" {
+					by := actorId(am["actor"])
+					if undone[by] == nil {
+						undone[by] = make(map[string]bool)
+					}
+					for _, id := range ids(am["object"]) {
+						undone[by][id] = true
+					}
+				}
+			}
+		}
+	}
+	var restored []block
+	for _, m := range blocks {
+		if undone[actorId(m["actor"])][stringValue(m["id"])] {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, stringValue(m["published"]))
+		if err != nil {
+			t = a.clock.Now()
+		}
+		for _, bl := range blocksOf(m, t) {
+			a.blocks.addBlock(bl)
+			restored = append(restored, bl)
+		}
+	}
+	if len(restored) == 0 {
+		return nil
+	}
+	log.Printf("restored %d blocks", len(restored))
+	go func() {
+		for _, bl := range restored {
+			u, err := url.Parse(bl.Actor)
+			if err != nil {
+				continue
+			}
+			if inbox, err := a.inboxOf(context.Background(), u); err != nil {
+				log.Printf("blocking %s without knowing its inbox: %s", bl.Actor, err)
+			} else {
+				a.blocks.setInbox(bl.Block, bl.Actor, inbox.String())
+			}
+		}
+	}()
+	return nil
+}
+
+func (a *app) blockedDomainsURL() *url.URL {
+	return &url.URL{Scheme: a.scheme, Host: a.host, Path: adminBlocksPath}
+}
+
+// saveBlockedDomains stores the blocked domains as a collection, so the
+// domains blocked at /admin/blocks survive restarts with a durable Store.
+func (a *app) saveBlockedDomains(domains []string) {
+	items := make([]interface{}, 0, len(domains))
+	for _, d := range domains {
+		items = append(items, d)
+	}
+	oc := &vocab.OrderedCollection{}
+	err := oc.Deserialize(map[string]interface{}{
+		"id":           a.blockedDomainsURL().String(),
+		"type":         "OrderedCollection",
+		"orderedItems": items,
+	})
+	if err == nil {
+		err = a.store.Put(context.Background(), oc)
+	}
+	if err != nil {
+		log.Printf("cannot store blocked domains: %s", err)
+	}
+}
+
+// restoreBlockedDomains blocks the domains stored by saveBlockedDomains again.
+func (a *app) restoreBlockedDomains(c context.Context) error {
+	id := a.blockedDomainsURL()
+	if has, err := a.store.Has(c, id); err != nil || !has {
+		return err
+	}
+	oc, err := a.getCollection(c, id)
+	if err != nil {
+		return err
+	}
+	m, err := oc.Serialize()
+	if err != nil {
+		return err
+	}
+	items := orderedItems(m)
+	for _, item := range items {
+		if d := stringValue(item); len(d) > 0 {
+			a.blocks.addDomain(d)
+		}
+	}
+	if len(items) > 0 {
+		log.Printf("restored %d blocked domains", len(items))
+	}
+	return nil
+}
+
+// storedJSON returns the serialization of the stored object with the given
+// id, if there is one.
+func (a *app) storedJSON(c context.Context, id string) (map[string]interface{}, bool) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, false
+	}
+	o, err := a.store.Get(c, u, pub.Read)
+	if err != nil {
+		return nil, false
+	}
+	m, err := o.Serialize()
+	return m, err == nil
+}
+
+func (a *app) Unblocked(c context.Context, actorIRIs []*url.URL) error {
+	for _, iri := range actorIRIs {
+		if a.blocks.blocked(iri) {
+			log.Printf("refusing activity from blocked %s", iri)
+			return fmt.Errorf("%s is blocked", iri)
+		}
+	}
+	return nil
+}
+
+// blockingCallbacker keeps the blocklist up to date with the Blocks, and the
+// Undos of Blocks, sent from the outboxes of the hosted actors. Other
+// callbacks go straight to the embedded Callbacker.
+type blockingCallbacker struct {
+	pub.Callbacker
+	app *app
+}
+
+func (b *blockingCallbacker) Block(c context.Context, s *streams.Block) error {
+	m, err := s.Raw().Serialize()
+	if err != nil {
+		return err
+	}
+	for _, bl := range blocksOf(m, b.app.clock.Now()) {
+		if u, err := url.Parse(bl.Actor); err != nil {
+			log.Printf("cannot block %q: %s", bl.Actor, err)
+			continue
+		} else if inbox, err := b.app.inboxOf(c, u); err != nil {
+			log.Printf("blocking %s without knowing its inbox: %s", bl.Actor, err)
+		} else {
+			bl.Inbox = inbox.String()
+		}
+		log.Printf("%s blocked %s", bl.BlockedBy, bl.Actor)
+		b.app.blocks.addBlock(bl)
+	}
+	return b.Callbacker.Block(c, s)
+}
+
+func (b *blockingCallbacker) Undo(c context.Context, s *streams.Undo) error {
+	m, err := s.Raw().Serialize()
+	if err != nil {
+		return err
+	}
+	by := actorId(m["actor"])
+	for _, id := range ids(m["object"]) {
+		if b.app.blocks.removeBlock(id, by) {
+			log.Printf("%s undid block %s", by, id)
+		}
+	}
+	return b.Callbacker.Undo(c, s)
+}
+
+// blockingDeliverer skips deliveries to blocked actors and domains.
+type blockingDeliverer struct {
+	blocks *blocklist
+	next   pub.Deliverer
+}
+
+func (b *blockingDeliverer) Do(body []byte, to *url.URL, toDo func(b []byte, u *url.URL) error) {
+	if b.blocks.blocked(to) {
+		log.Printf("not delivering to blocked %s", to)
+		return
+	}
+	b.next.Do(body, to, toDo)
+}
+
+// domainRequest is what administrators POST to block a domain.
+type domainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// serveAdminBlocks serves the blocklist as a collection on GET, blocks the
+// domain of a JSON encoded domainRequest on POST, and unblocks the domain
+// query parameter on DELETE.
+func (a *app) serveAdminBlocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		id := url.URL{Scheme: a.scheme, Host: a.host, Path: adminBlocksPath}
+		w.Header().Set("Content-Type", ldJSONType)
+		writeJSON(w, http.StatusOK, a.blocks.collection(id.String()))
+	case http.MethodPost:
+		var d domainRequest
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if len(d.Domain) == 0 {
+			http.Error(w, "missing domain", http.StatusBadRequest)
+			return
+		}
+		log.Printf("blocking domain %s", d.Domain)
+		a.blocks.addDomain(d.Domain)
+		a.blocks.changed()
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		d := r.URL.Query().Get(domainParam)
+		if len(d) == 0 {
+			http.Error(w, "missing domain", http.StatusBadRequest)
+			return
+		}
+		log.Printf("unblocking domain %s", d)
+		a.blocks.removeDomain(d)
+		a.blocks.changed()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,139 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"github.com/go-fed/activity/vocab"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlocksRestoredFromOutbox(t *testing.T) {
+	at := newAccessTest(t)
+	alice := at.alice.actorURL.String()
+	blocked := "https://blocked.example/users/eve"
+	unblocked := "https://unblocked.example/users/mallory"
+	put := func(m mapObject) {
+		if err := at.app.store.Put(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(mapObject{"id": at.srv.URL + "/new/1", "type": "Block", "actor": alice, "object": blocked, "published": "2019-01-01T00:00:00Z"})
+	put(mapObject{"id": at.srv.URL + "/new/2", "type": "Block", "actor": alice, "object": unblocked})
+	outbox := &vocab.OrderedCollection{}
+	if err := outbox.Deserialize(map[string]interface{}{
+		"id":   at.alice.outboxURL.String(),
+		"type": "OrderedCollection",
+		"orderedItems": []interface{}{
+			map[string]interface{}{"id": at.srv.URL + "/new/3", "type": "Undo", "actor": alice, "object": at.srv.URL + "/new/2"},
+			// Only the actor who blocked can undo a Block.
+			map[string]interface{}{"id": at.srv.URL + "/new/4", "type": "Undo", "actor": at.bob.ActorIRI().String(), "object": at.srv.URL + "/new/1"},
+			at.srv.URL + "/new/2",
+			at.srv.URL + "/new/1",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := at.app.store.Put(context.Background(), outbox); err != nil {
+		t.Fatal(err)
+	}
+
+	at.app.blocks = newBlocklist(nil)
+	if err := at.app.restoreBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		actor string
+		want  bool
+	}{
+		{blocked, true},
+		{unblocked, false},
+	} {
+		u, _ := url.Parse(test.actor)
+		if got := at.app.blocks.blocked(u); got != test.want {
+			t.Errorf("%s blocked: got %v, want %v", test.actor, got, test.want)
+		}
+	}
+	if bl := at.app.blocks.blocks; len(bl) != 1 || !bl[0].Time.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) || bl[0].BlockedBy != alice {
+		t.Errorf("got blocks %v", bl)
+	}
+}
+
+func TestBlockUndoneOnlyByBlocker(t *testing.T) {
+	blocks := newBlocklist(nil)
+	blocks.addBlock(block{Actor: "https://blocked.example/users/eve", BlockedBy: "https://local.example/users/alice", Block: "https://local.example/new/1"})
+	eve, _ := url.Parse("https://blocked.example/users/eve")
+	if blocks.removeBlock("https://local.example/new/1", "https://blocked.example/users/eve") || !blocks.blocked(eve) {
+		t.Error("Block undone by the blocked actor")
+	}
+	if !blocks.removeBlock("https://local.example/new/1", "https://local.example/users/alice") || blocks.blocked(eve) {
+		t.Error("Block not undone by the blocking actor")
+	}
+}
+
+func TestQueuedDeliveryDroppedOnceBlocked(t *testing.T) {
+	blocks := newBlocklist(nil)
+	q, err := newQueueDeliverer(DeliveryQueueConfig{Backoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}, newDeliveryHistory(10), nil, blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+	attempts := 0
+	to, _ := url.Parse("https://peer.example/inbox")
+	q.Do([]byte("{}"), to, func(b []byte, u *url.URL) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// The peer is blocked while its first delivery is retried.
+		blocks.addDomain("peer.example")
+		return errors.New("unreachable")
+	})
+	time.Sleep(300 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("attempted %d times, want 1", attempts)
+	}
+	if dead := q.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got dead letters %v", dead)
+	}
+}
+
+func TestBlockedDomainsRestored(t *testing.T) {
+	at := newAccessTest(t)
+	at.app.blocks.save = at.app.saveBlockedDomains
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, adminBlocksPath, strings.NewReader(`{"domain": "Spam.example"}`)),
+		httptest.NewRequest(http.MethodPost, adminBlocksPath, strings.NewReader(`{"domain": "forgiven.example"}`)),
+		httptest.NewRequest(http.MethodDelete, adminBlocksPath+"?domain=forgiven.example", nil),
+	} {
+		rec := httptest.NewRecorder()
+		at.app.serveAdminBlocks(rec, r)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s %s: got %d", r.Method, r.URL, rec.Code)
+		}
+	}
+
+	// A restart keeps the store but not the blocklist.
+	at.app.blocks = newBlocklist(nil)
+	if err := at.app.restoreBlockedDomains(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		actor string
+		want  bool
+	}{
+		{"https://spam.example/users/eve", true},
+		{"https://forgiven.example/users/mallory", false},
+	} {
+		u, _ := url.Parse(test.actor)
+		if got := at.app.blocks.blocked(u); got != test.want {
+			t.Errorf("%s blocked: got %v, want %v", test.actor, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package report
+
+import (
+	"context"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	adminCallbacksPath = "/admin/callbacks"
+	// defaultCallbackHistorySize is the number of callbacks remembered,
+	// after which the oldest are forgotten.
+	defaultCallbackHistorySize = 1000
+	sideParam                  = "side"
+	correlationIdParam         = "correlationId"
+)
+
+const (
+	// SocialSide marks callbacks for activities posted to an outbox.
+	SocialSide = "social"
+	// FederatedSide marks callbacks for activities received in an inbox.
+	FederatedSide = "federated"
+)
+
+var _ pub.Callbacker = &recordingCallbacker{}
+
+// CallbackRecord is one callback made by go-fed/activity once it handled an
+// activity.
+type CallbackRecord struct {
+	Time time.Time `json:"time"`
+	// Side is SocialSide or FederatedSide.
+	Side string `json:"side"`
+	// Callback is the type of the activity, such as "Create".
+	Callback string `json:"callback"`
+	// CorrelationId identifies the request the callback was made for. It
+	// is the lock key of the request, as in a TrafficRecord, or 0 if
+	// unknown.
+	CorrelationId int                    `json:"correlationId,omitempty"`
+	Activity      map[string]interface{} `json:"activity"`
+	// Error is set if the activity could not be serialized.
+	Error string `json:"error,omitempty"`
+}
+
+// CallbackRecorder remembers the latest callbacks made by go-fed/activity on
+// both the social and federated sides. Pass one to WithCallbackRecorder to
+// see which callbacks a request caused.
+type CallbackRecorder struct {
+	records []CallbackRecord
+	max     int
+	mu      *sync.Mutex
+}
+
+// NewCallbackRecorder creates a CallbackRecorder remembering the latest max
+// callbacks, or 1000 if max is not positive.
+func NewCallbackRecorder(max int) *CallbackRecorder {
+	if max <= 0 {
+		max = defaultCallbackHistorySize
+	}
+	return &CallbackRecorder{
+		max: max,
+		mu:  &sync.Mutex{},
+	}
+}
+
+// Records returns the remembered callbacks, oldest first.
+func (r *CallbackRecorder) Records() []CallbackRecord {
+	return r.matching("", 0)
+}
+
+// ForRequest returns the remembered callbacks made for the request with the
+// given correlation id, oldest first.
+func (r *CallbackRecorder) ForRequest(correlationId int) []CallbackRecord {
+	return r.matching("", correlationId)
+}
+
+// Reset forgets all callbacks remembered so far.
+func (r *CallbackRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// matching returns the callbacks of the side and correlation id. Empty
+// filters match everything.
+func (r *CallbackRecorder) matching(side string, correlationId int) []CallbackRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rs := []CallbackRecord{}
+	for _, rec := range r.records {
+		if (len(side) == 0 || rec.Side == side) && (correlationId == 0 || rec.CorrelationId == correlationId) {
+			rs = append(rs, rec)
+		}
+	}
+	return rs
+}
+
+type serializer interface {
+	Serialize() (map[string]interface{}, error)
+}
+
+func (r *CallbackRecorder) record(c context.Context, side, callback string, s serializer) {
+	rec := CallbackRecord{
+		Time:     time.Now(),
+		Side:     side,
+		Callback: callback,
+	}
+	rec.CorrelationId, _ = LockKey(c)
+	m, err := s.Serialize()
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Activity = m
+	}
+	log.Printf("%s %s callback for request %d", side, callback, rec.CorrelationId)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+	if len(r.records) > r.max {
+		r.records = append([]CallbackRecord(nil), r.records[len(r.records)-r.max:]...)
+	}
+}
+
+// serveAdmin lists the remembered callbacks on GET, filtered by the side and
+// correlationId query parameters, and forgets them on DELETE.
+func (r *CallbackRecorder) serveAdmin(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		var correlationId int
+		if q := req.URL.Query().Get(correlationIdParam); len(q) > 0 {
+			var err error
+			if correlationId, err = strconv.Atoi(q); err != nil {
+				http.Error(w, "invalid correlationId", http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, r.matching(req.URL.Query().Get(sideParam), correlationId))
+	case http.MethodDelete:
+		r.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// recordingCallbacker records each callback in rec, then makes it to next.
+type recordingCallbacker struct {
+	side string
+	rec  *CallbackRecorder
+	next pub.Callbacker
+}
+
+func (r *recordingCallbacker) Create(c context.Context, s *streams.Create) error {
+	r.rec.record(c, r.side, "Create", s.Raw())
+	return r.next.Create(c, s)
+}
+
+func (r *recordingCallbacker) Update(c context.Context, s *streams.Update) error {
+	r.rec.record(c, r.side, "Update", s.Raw())
+	return r.next.Update(c, s)
+}
+
+func (r *recordingCallbacker) Delete(c context.Context, s *streams.Delete) error {
+	r.rec.record(c, r.side, "Delete", s.Raw())
+	return r.next.Delete(c, s)
+}
+
+func (r *recordingCallbacker) Add(c context.Context, s *streams.Add) error {
+	r.rec.record(c, r.side, "Add", s.Raw())
+	return r.next.Add(c, s)
+}
+
+func (r *recordingCallbacker) Remove(c context.Context, s *streams.Remove) error {
+	r.rec.record(c, r.side, "Remove", s.Raw())
+	return r.next.Remove(c, s)
+}
+
+func (r *recordingCallbacker) Like(c context.Context, s *streams.Like) error {
+	r.rec.record(c, r.side, "Like", s.Raw())
+	return r.next.Like(c, s)
+}
+
+func (r *recordingCallbacker) Block(c context.Context, s *streams.Block) error {
+	r.rec.record(c, r.side, "Block", s.Raw())
+	return r.next.Block(c, s)
+}
+
+func (r *recordingCallbacker) Follow(c context.Context, s *streams.Follow) error {
+	r.rec.record(c, r.side, "Follow", s.Raw())
+	return r.next.Follow(c, s)
+}
+
+func (r *recordingCallbacker) Undo(c context.Context, s *streams.Undo) error {
+	r.rec.record(c, r.side, "Undo", s.Raw())
+	return r.next.Undo(c, s)
+}
+
+func (r *recordingCallbacker) Accept(c context.Context, s *streams.Accept) error {
+	r.rec.record(c, r.side, "Accept", s.Raw())
+	return r.next.Accept(c, s)
+}
+
+func (r *recordingCallbacker) Reject(c context.Context, s *streams.Reject) error {
+	r.rec.record(c, r.side, "Reject", s.Raw())
+	return r.next.Reject(c, s)
+}
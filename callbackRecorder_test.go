@@ -0,0 +1,109 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCallbackRecorder(t *testing.T) {
+	r := NewCallbackRecorder(3)
+	c1, cancel1 := lockedContext(1)
+	defer cancel1()
+	c2, cancel2 := lockedContext(2)
+	defer cancel2()
+	r.record(c1, SocialSide, "Create", mapObject{"type": "Create"})
+	r.record(c2, FederatedSide, "Follow", mapObject{"type": "Follow"})
+	r.record(c2, FederatedSide, "Undo", mapObject{"type": "Undo"})
+	r.record(context.Background(), FederatedSide, "Like", mapObject{"type": "Like"})
+
+	callbacks := func(rs []CallbackRecord) []string {
+		var cs []string
+		for _, rec := range rs {
+			cs = append(cs, rec.Callback)
+		}
+		return cs
+	}
+	for _, test := range []struct {
+		name string
+		got  []CallbackRecord
+		want []string
+	}{
+		// The Create is the oldest of four, and forgotten.
+		{"all", r.Records(), []string{"Follow", "Undo", "Like"}},
+		{"request 2", r.ForRequest(2), []string{"Follow", "Undo"}},
+		{"federated request 2", r.matching(FederatedSide, 2), []string{"Follow", "Undo"}},
+		{"social", r.matching(SocialSide, 0), nil},
+	} {
+		if got := callbacks(test.got); strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	if rs := r.ForRequest(2); len(rs) > 0 && (rs[0].Side != FederatedSide || stringValue(rs[0].Activity["type"]) != "Follow") {
+		t.Errorf("recorded %+v", rs[0])
+	}
+	if rs := r.Records(); len(rs) > 0 && rs[len(rs)-1].CorrelationId != 0 {
+		t.Errorf("callback without a lock key has correlation id %d", rs[len(rs)-1].CorrelationId)
+	}
+
+	r.Reset()
+	if rs := r.Records(); len(rs) != 0 {
+		t.Errorf("got %v after reset", rs)
+	}
+}
+
+func TestCallbackRecorderAdmin(t *testing.T) {
+	r := NewCallbackRecorder(0)
+	for i, side := range []string{SocialSide, FederatedSide, FederatedSide} {
+		c, cancel := lockedContext(i + 1)
+		defer cancel()
+		r.record(c, side, "Create", mapObject{"type": "Create"})
+	}
+	for _, test := range []struct {
+		query  string
+		status int
+		want   []int
+	}{
+		{"", http.StatusOK, []int{1, 2, 3}},
+		{"?side=federated", http.StatusOK, []int{2, 3}},
+		{"?side=federated&correlationId=3", http.StatusOK, []int{3}},
+		{"?side=social&correlationId=3", http.StatusOK, nil},
+		{"?correlationId=three", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		r.serveAdmin(rec, httptest.NewRequest(http.MethodGet, adminCallbacksPath+test.query, nil))
+		if rec.Code != test.status {
+			t.Errorf("%q: got %d, want %d", test.query, rec.Code, test.status)
+			continue
+		} else if rec.Code != http.StatusOK {
+			continue
+		}
+		var rs []CallbackRecord
+		if err := json.Unmarshal(rec.Body.Bytes(), &rs); err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, cb := range rs {
+			got = append(got, cb.CorrelationId)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got correlation ids %v, want %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got correlation ids %v, want %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	r.serveAdmin(rec, httptest.NewRequest(http.MethodDelete, adminCallbacksPath, nil))
+	if rec.Code != http.StatusNoContent || len(r.Records()) != 0 {
+		t.Errorf("DELETE: got %d, %d callbacks left", rec.Code, len(r.Records()))
+	}
+}
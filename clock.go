@@ -1,7 +1,12 @@
 package report
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/go-fed/activity/pub"
+	"log"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -14,3 +19,190 @@ type localClock struct{}
 func (l *localClock) Now() time.Time {
 	return time.Now()
 }
+
+const adminClockPath = "/admin/clock"
+
+var _ pub.Clock = &TestClock{}
+
+// TestClock is a clock that can be frozen, offset from the real time, and
+// advanced, so that timestamps in tests and replays are predictable.
+type TestClock struct {
+	frozen bool
+	at     time.Time
+	offset time.Duration
+	step   time.Duration
+	mu     *sync.Mutex
+}
+
+// NewTestClock creates a TestClock telling the real time.
+func NewTestClock() *TestClock {
+	return &TestClock{mu: &sync.Mutex{}}
+}
+
+// ParseTestClock creates a TestClock from spec, which is either empty for the
+// real time, an RFC 3339 time to freeze the clock at, or a duration with a
+// sign, such as "+1h" or "-30m", to offset the real time by.
+func ParseTestClock(spec string) (*TestClock, error) {
+	t := NewTestClock()
+	if len(spec) == 0 {
+		return t, nil
+	} else if spec[0] == '+' || spec[0] == '-' {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, err
+		}
+		t.Offset(d)
+		return t, nil
+	}
+	at, err := time.Parse(time.RFC3339, spec)
+	if err != nil {
+		return nil, fmt.Errorf("clock must be an RFC 3339 time or a signed duration: %s", err)
+	}
+	t.Freeze(at)
+	return t, nil
+}
+
+// Now returns the time of the clock. A frozen clock with a step moves forward
+// by the step after each call.
+func (t *TestClock) Now() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.frozen {
+		return time.Now().Add(t.offset)
+	}
+	now := t.at
+	t.at = t.at.Add(t.step)
+	return now
+}
+
+// Freeze stops the clock at at.
+func (t *TestClock) Freeze(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frozen = true
+	t.at = at
+}
+
+// Offset makes the clock tell the real time plus d, unfreezing it.
+func (t *TestClock) Offset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frozen = false
+	t.offset = d
+}
+
+// Advance moves the clock forward by d, or backward if d is negative.
+func (t *TestClock) Advance(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.frozen {
+		t.at = t.at.Add(d)
+	} else {
+		t.offset += d
+	}
+}
+
+// SetStep makes a frozen clock move forward by d after each call to Now, so
+// consecutive timestamps differ but remain predictable.
+func (t *TestClock) SetStep(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.step = d
+}
+
+// Reset makes the clock tell the real time again.
+func (t *TestClock) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frozen = false
+	t.offset = 0
+	t.step = 0
+}
+
+// testClockState describes a TestClock to administrators, and changes it when
+// posted. Durations are as parsed by time.ParseDuration.
+type testClockState struct {
+	Now     string `json:"now,omitempty"`
+	Frozen  bool   `json:"frozen"`
+	Freeze  string `json:"freeze,omitempty"`
+	Offset  string `json:"offset,omitempty"`
+	Advance string `json:"advance,omitempty"`
+	Step    string `json:"step,omitempty"`
+	Reset   bool   `json:"reset,omitempty"`
+}
+
+func (t *TestClock) state() testClockState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := testClockState{
+		Frozen: t.frozen,
+		Offset: t.offset.String(),
+		Step:   t.step.String(),
+	}
+	if t.frozen {
+		s.Now = t.at.Format(time.RFC3339Nano)
+	} else {
+		s.Now = time.Now().Add(t.offset).Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+// serveAdmin describes the clock on GET. On POST, it applies the changes in a
+// JSON encoded testClockState in the order reset, freeze, offset, step and
+// advance, then describes the clock.
+func (t *TestClock) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var s testClockState
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var at time.Time
+		var offset, step, advance time.Duration
+		var err error
+		if len(s.Freeze) > 0 {
+			if at, err = time.Parse(time.RFC3339, s.Freeze); err != nil {
+				http.Error(w, fmt.Sprintf("invalid freeze: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		for _, d := range []struct {
+			name string
+			s    string
+			d    *time.Duration
+		}{
+			{"offset", s.Offset, &offset},
+			{"step", s.Step, &step},
+			{"advance", s.Advance, &advance},
+		} {
+			if len(d.s) == 0 {
+				continue
+			} else if *d.d, err = time.ParseDuration(d.s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %s", d.name, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if s.Reset {
+			t.Reset()
+		}
+		if len(s.Freeze) > 0 {
+			t.Freeze(at)
+		}
+		if len(s.Offset) > 0 {
+			t.Offset(offset)
+		}
+		if len(s.Step) > 0 {
+			t.SetStep(step)
+		}
+		if len(s.Advance) > 0 {
+			t.Advance(advance)
+		}
+		log.Printf("clock set to %+v", s)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.state())
+}
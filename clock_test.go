@@ -0,0 +1,134 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestClock(t *testing.T) {
+	jan1 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		// want is the time the clock tells, or the zero time for an offset
+		// from the real time by offset.
+		want   time.Time
+		offset time.Duration
+		err    bool
+	}{
+		{spec: ""},
+		{spec: "+1h", offset: time.Hour},
+		{spec: "-30m", offset: -30 * time.Minute},
+		{spec: "2019-01-01T00:00:00Z", want: jan1},
+		{spec: "+soon", err: true},
+		{spec: "tomorrow", err: true},
+	} {
+		c, err := ParseTestClock(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: parsed", test.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		now := c.Now()
+		if !test.want.IsZero() {
+			if !now.Equal(test.want) {
+				t.Errorf("%q: got %s, want %s", test.spec, now, test.want)
+			}
+		} else if d := now.Sub(time.Now().Add(test.offset)); d < -time.Second || d > time.Second {
+			t.Errorf("%q: got %s, %s off the real time plus %s", test.spec, now, d, test.offset)
+		}
+	}
+}
+
+func TestTestClock(t *testing.T) {
+	jan1 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewTestClock()
+	c.Freeze(jan1)
+	if now := c.Now(); !now.Equal(jan1) || !c.Now().Equal(jan1) {
+		t.Errorf("frozen clock moved from %s", jan1)
+	}
+	c.Advance(time.Hour)
+	if now := c.Now(); !now.Equal(jan1.Add(time.Hour)) {
+		t.Errorf("advanced to %s, want %s", now, jan1.Add(time.Hour))
+	}
+
+	c.Freeze(jan1)
+	c.SetStep(time.Second)
+	for i := 0; i < 3; i++ {
+		if now, want := c.Now(), jan1.Add(time.Duration(i)*time.Second); !now.Equal(want) {
+			t.Errorf("step %d: got %s, want %s", i, now, want)
+		}
+	}
+
+	c.Offset(-24 * time.Hour)
+	c.Advance(time.Hour)
+	if d := c.Now().Sub(time.Now().Add(-23 * time.Hour)); d < -time.Second || d > time.Second {
+		t.Errorf("offset clock is %s off", d)
+	}
+
+	c.Reset()
+	if d := c.Now().Sub(time.Now()); d < -time.Second || d > time.Second {
+		t.Errorf("reset clock is %s off the real time", d)
+	}
+	if s := c.state(); s.Frozen || s.Step != "0s" {
+		t.Errorf("reset clock is %+v", s)
+	}
+}
+
+func serveAdminClock(t *testing.T, c *TestClock, method, body string) (int, testClockState) {
+	rec := httptest.NewRecorder()
+	c.serveAdmin(rec, httptest.NewRequest(method, adminClockPath, strings.NewReader(body)))
+	var s testClockState
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, s
+}
+
+func TestTestClockAdmin(t *testing.T) {
+	c := NewTestClock()
+	if code, s := serveAdminClock(t, c, http.MethodGet, ""); code != http.StatusOK || s.Frozen {
+		t.Errorf("GET: got %d %+v", code, s)
+	}
+
+	// Advance applies after freeze, whatever the order of the fields.
+	code, s := serveAdminClock(t, c, http.MethodPost, `{"advance": "10m", "freeze": "2019-01-01T00:00:00Z", "step": "1s"}`)
+	if want := "2019-01-01T00:10:00Z"; code != http.StatusOK || !s.Frozen || s.Now != want || s.Step != "1s" {
+		t.Errorf("POST: got %d %+v, want frozen at %s", code, s, want)
+	}
+	if now, want := c.Now(), time.Date(2019, 1, 1, 0, 10, 0, 0, time.UTC); !now.Equal(want) {
+		t.Errorf("clock tells %s, want %s", now, want)
+	}
+
+	for _, body := range []string{
+		`{"freeze": "yesterday"}`,
+		`{"offset": "1 hour"}`,
+		`{"step": "fast"}`,
+		`{"advance": "a bit"}`,
+		`not json`,
+	} {
+		if code, _ := serveAdminClock(t, c, http.MethodPost, body); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", body, code, http.StatusBadRequest)
+		}
+	}
+	// An invalid request changes nothing.
+	if code, s := serveAdminClock(t, c, http.MethodGet, ""); code != http.StatusOK || !s.Frozen || s.Step != "1s" {
+		t.Errorf("GET after invalid POSTs: got %d %+v", code, s)
+	}
+
+	if code, s := serveAdminClock(t, c, http.MethodPost, `{"reset": true}`); code != http.StatusOK || s.Frozen {
+		t.Errorf("reset: got %d %+v", code, s)
+	}
+	if code, _ := serveAdminClock(t, c, http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: got %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
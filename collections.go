@@ -0,0 +1,137 @@
+package report
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPageSize is the number of items in each collection page.
+	defaultPageSize = 20
+	pageParam       = "page"
+)
+
+// isActivityPubGet determines whether r asks for an ActivityStreams
+// representation.
+func isActivityPubGet(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, accept := range r.Header["Accept"] {
+		for _, t := range strings.Split(accept, ",") {
+			t = strings.TrimSpace(t)
+			if strings.HasPrefix(t, activityJSONType) ||
+				(strings.HasPrefix(t, "application/ld+json") && strings.Contains(t, activityStreamsContext)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveCollection serves the collections of actors in pages. The collection
+// itself is served as a summary with its totalItems and links to the first
+// and last pages, and each page of pageSize items is served at the
+// collection's id with a page query parameter, counting from 1. Only the
+// items the requester may see are counted and served.
+//
+// It has the signature of a pub.HandlerFunc and does not handle requests for
+// anything but the collections.
+func (a *app) serveCollection(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	if !isActivityPubGet(r) {
+		return false, nil
+	}
+	id := *r.URL
+	id.RawQuery = ""
+	if !a.isCollection(&id) {
+		return false, nil
+	}
+	oc, err := a.getCollection(c, &id)
+	if err != nil {
+		return true, err
+	}
+	m, err := oc.Serialize()
+	if err != nil {
+		return true, err
+	}
+	items := a.visibleItems(c, orderedItems(m), a.requester(c, r))
+	pages := (len(items) + a.pageSize - 1) / a.pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	pageURL := func(n int) string {
+		u := id
+		u.RawQuery = url.Values{pageParam: []string{strconv.Itoa(n)}}.Encode()
+		return u.String()
+	}
+	var doc map[string]interface{}
+	if q := r.URL.Query().Get(pageParam); len(q) == 0 {
+		doc = map[string]interface{}{
+			"@context":   activityStreamsContext,
+			"id":         id.String(),
+			"type":       "OrderedCollection",
+			"totalItems": len(items),
+			"first":      pageURL(1),
+			"last":       pageURL(pages),
+		}
+	} else {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > pages {
+			w.WriteHeader(http.StatusNotFound)
+			return true, nil
+		}
+		start := (n - 1) * a.pageSize
+		end := start + a.pageSize
+		if end > len(items) {
+			end = len(items)
+		}
+		doc = map[string]interface{}{
+			"@context":     activityStreamsContext,
+			"id":           pageURL(n),
+			"type":         "OrderedCollectionPage",
+			"partOf":       id.String(),
+			"totalItems":   len(items),
+			"startIndex":   start,
+			"orderedItems": items[start:end],
+		}
+		if n > 1 {
+			doc["prev"] = pageURL(n - 1)
+		}
+		if n < pages {
+			doc["next"] = pageURL(n + 1)
+		}
+	}
+	w.Header().Set("Content-Type", ldJSONType)
+	writeJSON(w, http.StatusOK, doc)
+	return true, nil
+}
+
+// visibleItems returns the items that user may see, which is nil for
+// anonymous requests. Embedded objects are dropped unless addressed to user,
+// while bare ids are kept since dereferencing them is access controlled.
+func (a *app) visibleItems(c context.Context, items []interface{}, user *url.URL) []interface{} {
+	visible := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok && !a.visibleTo(c, m, user) {
+			continue
+		}
+		visible = append(visible, item)
+	}
+	return visible
+}
+
+// orderedItems returns the items of a serialized OrderedCollection, which may
+// be a single value rather than an array.
+func orderedItems(m map[string]interface{}) []interface{} {
+	switch v := m["orderedItems"].(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
@@ -10,11 +10,13 @@ var _ pub.Deliverer = &syncDeliverer{}
 
 // Synchronously tries to deliver, immediately. Real applications may want to
 // rate limit, back off, and retry across downtimes.
-type syncDeliverer struct{}
+type syncDeliverer struct {
+	history *deliveryHistory
+}
 
 func (s *syncDeliverer) Do(b []byte, to *url.URL, toDo func(b []byte, u *url.URL) error) {
 	log.Printf("Delivering to: %s", to)
-	err := toDo(b, to)
+	err := s.history.do(b, to, 1, toDo)
 	if err != nil {
 		log.Print(err)
 	}
@@ -0,0 +1,199 @@
+package report
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-fed/activity/pub"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	adminDeliveriesPath = "/admin/deliveries"
+	// defaultDeliveryHistorySize is the number of delivery attempts
+	// remembered, after which the oldest are forgotten.
+	defaultDeliveryHistorySize = 1000
+	activityParam              = "activity"
+	recipientParam             = "recipient"
+)
+
+// deliveryAttempt is one attempt at delivering an activity to an inbox.
+type deliveryAttempt struct {
+	Time        time.Time `json:"time"`
+	ActivityId  string    `json:"activityId,omitempty"`
+	Target      string    `json:"target"`
+	PayloadHash string    `json:"payloadHash"`
+	Attempt     int       `json:"attempt"`
+	Delivered   bool      `json:"delivered"`
+	// Status is the response code of the inbox, or 0 if there was no
+	// response.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// deliveryHistory remembers the latest delivery attempts made by either
+// Deliverer.
+type deliveryHistory struct {
+	attempts []deliveryAttempt
+	max      int
+	// statuses are the response codes to POSTs made by historyClient, by
+	// statusKey, until the attempt making them is recorded.
+	statuses map[string]int
+	mu       *sync.Mutex
+}
+
+func newDeliveryHistory(max int) *deliveryHistory {
+	return &deliveryHistory{
+		max:      max,
+		statuses: make(map[string]int),
+		mu:       &sync.Mutex{},
+	}
+}
+
+func payloadHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func statusKey(target, hash string) string {
+	return target + " " + hash
+}
+
+// activityId returns the id of the serialized activity b, if any.
+func activityId(b []byte) string {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return ""
+	}
+	return stringValue(m["id"])
+}
+
+// do delivers b to the inbox to with toDo, and records the attempt.
+func (h *deliveryHistory) do(b []byte, to *url.URL, attempt int, toDo func(b []byte, u *url.URL) error) error {
+	err := toDo(b, to)
+	a := deliveryAttempt{
+		Time:        time.Now(),
+		ActivityId:  activityId(b),
+		Target:      to.String(),
+		PayloadHash: payloadHash(b),
+		Attempt:     attempt,
+		Delivered:   err == nil,
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := statusKey(a.Target, a.PayloadHash)
+	a.Status = h.statuses[key]
+	delete(h.statuses, key)
+	if a.Status != 0 && (a.Status < 200 || a.Status >= 300) {
+		a.Delivered = false
+	}
+	h.attempts = append(h.attempts, a)
+	if len(h.attempts) > h.max {
+		h.attempts = append([]deliveryAttempt(nil), h.attempts[len(h.attempts)-h.max:]...)
+	}
+	return err
+}
+
+func (h *deliveryHistory) noteStatus(target, hash string, status int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses[statusKey(target, hash)] = status
+}
+
+// matching returns the attempts for the activity and recipient, oldest first.
+// Empty filters match everything.
+func (h *deliveryHistory) matching(activity, recipient string) []deliveryAttempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	as := []deliveryAttempt{}
+	for _, a := range h.attempts {
+		if (len(activity) == 0 || a.ActivityId == activity) && (len(recipient) == 0 || a.Target == recipient) {
+			as = append(as, a)
+		}
+	}
+	return as
+}
+
+// historyClient notes the response codes to the POSTs made by client, which
+// the functions delivering activities do not return.
+type historyClient struct {
+	client  pub.HttpClient
+	history *deliveryHistory
+}
+
+func (c *historyClient) Do(req *http.Request) (*http.Response, error) {
+	var hash string
+	if req.Method == http.MethodPost && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, err := ioutil.ReadAll(body)
+			body.Close()
+			if err == nil {
+				hash = payloadHash(b)
+			}
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err == nil && len(hash) > 0 {
+		c.history.noteStatus(req.URL.String(), hash, resp.StatusCode)
+	}
+	return resp, err
+}
+
+// deadLetter describes a delivery given up on to administrators.
+type deadLetter struct {
+	ActivityId  string          `json:"activityId,omitempty"`
+	Target      string          `json:"target"`
+	PayloadHash string          `json:"payloadHash"`
+	Attempts    int             `json:"attempts"`
+	Created     time.Time       `json:"created"`
+	LastError   string          `json:"lastError,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+func newDeadLetter(d delivery) deadLetter {
+	dl := deadLetter{
+		ActivityId:  activityId(d.Body),
+		Target:      d.To,
+		PayloadHash: payloadHash(d.Body),
+		Attempts:    d.Attempts,
+		Created:     d.Created,
+		LastError:   d.LastError,
+	}
+	if json.Valid(d.Body) {
+		dl.Payload = json.RawMessage(bytes.TrimSpace(d.Body))
+	}
+	return dl
+}
+
+// serveAdminDeliveries lists the delivery attempts remembered by h and the
+// dead letters of q, if the delivery queue is used, filtered by the activity
+// and recipient query parameters.
+func serveAdminDeliveries(w http.ResponseWriter, r *http.Request, h *deliveryHistory, q *queueDeliverer) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	activity := r.URL.Query().Get(activityParam)
+	recipient := r.URL.Query().Get(recipientParam)
+	dead := []deadLetter{}
+	if q != nil {
+		for _, d := range q.DeadLetters() {
+			dl := newDeadLetter(d)
+			if (len(activity) == 0 || dl.ActivityId == activity) && (len(recipient) == 0 || dl.Target == recipient) {
+				dead = append(dead, dl)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"attempts":    h.matching(activity, recipient),
+		"deadLetters": dead,
+	})
+}
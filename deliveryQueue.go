@@ -0,0 +1,309 @@
+package report
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeliveryWorkers     = 4
+	defaultDeliveryMaxAttempts = 8
+	defaultDeliveryPerHost     = 2
+	defaultDeliveryBackoff     = time.Second
+	defaultDeliveryMaxBackoff  = time.Hour
+	// hostBusyDelay is how long a delivery waits when its host already has
+	// as many deliveries in flight as allowed. It does not count as an
+	// attempt.
+	hostBusyDelay    = 100 * time.Millisecond
+	pendingDirName   = "pending"
+	deadDirName      = "dead"
+	deliveryFileExt  = ".json"
+	readyQueueLength = 1024
+)
+
+var _ pub.Deliverer = &queueDeliverer{}
+
+// DeliveryQueueConfig configures the queue used by WithDeliveryQueue. Zero
+// values are replaced by defaults.
+type DeliveryQueueConfig struct {
+	// Workers is the number of deliveries made at once. Defaults to 4.
+	Workers int
+	// MaxAttempts is the number of attempts after which a delivery is
+	// given up on and put in the dead-letter list. Defaults to 8.
+	MaxAttempts int
+	// PerHost is the number of deliveries made at once to any one host.
+	// Defaults to 2.
+	PerHost int
+	// Backoff is the delay before the first retry, doubled for each retry
+	// after that, up to MaxBackoff. Defaults to a second and an hour.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Dir, if set, keeps pending deliveries and the dead-letter list on
+	// disk so they survive restarts.
+	Dir string
+}
+
+// delivery is an activity waiting to be delivered to one inbox.
+type delivery struct {
+	Id        string    `json:"id"`
+	Body      []byte    `json:"body"`
+	To        string    `json:"to"`
+	Attempts  int       `json:"attempts"`
+	Created   time.Time `json:"created"`
+	LastError string    `json:"lastError,omitempty"`
+	to        *url.URL
+	toDo      func(b []byte, u *url.URL) error
+}
+
+// queueDeliverer delivers in the background with a pool of workers, retrying
+// failed deliveries with exponential backoff and jitter. Deliveries failing
+// MaxAttempts times are kept in a dead-letter list.
+type queueDeliverer struct {
+	cfg     DeliveryQueueConfig
+	ready   chan *delivery
+	hosts   map[string]int
+	dead    []*delivery
+	mu      *sync.Mutex
+	history *deliveryHistory
+	restore func(b []byte, u *url.URL) error
+	// blocks may have grown since a delivery was queued.
+	blocks *blocklist
+}
+
+// newQueueDeliverer starts the workers of a queue, recording attempts in
+// history. Deliveries found on disk in cfg.Dir are resumed with restore, as the
+// functions given to Do are lost across restarts. Deliveries to what blocks
+// blocks by the time they are attempted are dropped.
+func newQueueDeliverer(cfg DeliveryQueueConfig, history *deliveryHistory, restore func(b []byte, u *url.URL) error, blocks *blocklist) (*queueDeliverer, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultDeliveryWorkers
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultDeliveryMaxAttempts
+	}
+	if cfg.PerHost <= 0 {
+		cfg.PerHost = defaultDeliveryPerHost
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultDeliveryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultDeliveryMaxBackoff
+	}
+	q := &queueDeliverer{
+		cfg:     cfg,
+		ready:   make(chan *delivery, readyQueueLength),
+		hosts:   make(map[string]int),
+		mu:      &sync.Mutex{},
+		history: history,
+		restore: restore,
+		blocks:  blocks,
+	}
+	var pending []*delivery
+	if len(cfg.Dir) > 0 {
+		for _, d := range []string{pendingDirName, deadDirName} {
+			if err := os.MkdirAll(filepath.Join(cfg.Dir, d), 0755); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if pending, err = q.load(pendingDirName); err != nil {
+			return nil, err
+		}
+		if q.dead, err = q.load(deadDirName); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+	for _, d := range pending {
+		log.Printf("resuming delivery to %s after %d attempts", d.to, d.Attempts)
+		q.schedule(d, 0)
+	}
+	return q, nil
+}
+
+func (q *queueDeliverer) Do(b []byte, to *url.URL, toDo func(b []byte, u *url.URL) error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("cannot queue delivery to %s: %s", to, err)
+		return
+	}
+	d := &delivery{
+		Id:      hex.EncodeToString(id),
+		Body:    b,
+		To:      to.String(),
+		Created: time.Now(),
+		to:      to,
+		toDo:    toDo,
+	}
+	if err := q.persist(pendingDirName, d); err != nil {
+		log.Printf("cannot persist delivery to %s: %s", to, err)
+	}
+	log.Printf("queued delivery to %s", to)
+	q.schedule(d, 0)
+}
+
+// DeadLetters returns the deliveries given up on, oldest first.
+func (q *queueDeliverer) DeadLetters() []delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dead := make([]delivery, len(q.dead))
+	for i, d := range q.dead {
+		dead[i] = *d
+	}
+	return dead
+}
+
+// schedule makes d ready after delay.
+func (q *queueDeliverer) schedule(d *delivery, delay time.Duration) {
+	if delay <= 0 {
+		go func() { q.ready <- d }()
+		return
+	}
+	time.AfterFunc(delay, func() { q.ready <- d })
+}
+
+// backoff is the delay before retrying a delivery that failed attempts times:
+// Backoff doubled for each attempt after the first, capped at MaxBackoff, of
+// which a random half is waited.
+func (q *queueDeliverer) backoff(attempts int) time.Duration {
+	delay := q.cfg.Backoff
+	for i := 1; i < attempts && delay < q.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.cfg.MaxBackoff {
+		delay = q.cfg.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(mrand.Int63n(int64(half)+1))
+}
+
+func (q *queueDeliverer) work() {
+	for d := range q.ready {
+		if q.blocks.blocked(d.to) {
+			log.Printf("not delivering to blocked %s", d.to)
+			q.remove(pendingDirName, d)
+			continue
+		}
+		if !q.acquireHost(d.to.Host) {
+			q.schedule(d, hostBusyDelay)
+			continue
+		}
+		d.Attempts++
+		err := q.history.do(d.Body, d.to, d.Attempts, d.toDo)
+		q.releaseHost(d.to.Host)
+		if err == nil {
+			log.Printf("delivered to %s after %d attempts", d.to, d.Attempts)
+			q.remove(pendingDirName, d)
+			continue
+		}
+		d.LastError = err.Error()
+		if d.Attempts >= q.cfg.MaxAttempts {
+			log.Printf("giving up delivering to %s after %d attempts: %s", d.to, d.Attempts, err)
+			q.mu.Lock()
+			q.dead = append(q.dead, d)
+			q.mu.Unlock()
+			if err := q.persist(deadDirName, d); err != nil {
+				log.Printf("cannot persist dead letter to %s: %s", d.to, err)
+			}
+			q.remove(pendingDirName, d)
+			continue
+		}
+		delay := q.backoff(d.Attempts)
+		log.Printf("delivering to %s failed, attempt %d of %d, retrying in %s: %s", d.to, d.Attempts, q.cfg.MaxAttempts, delay, err)
+		if err := q.persist(pendingDirName, d); err != nil {
+			log.Printf("cannot persist delivery to %s: %s", d.to, err)
+		}
+		q.schedule(d, delay)
+	}
+}
+
+func (q *queueDeliverer) acquireHost(host string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.hosts[host] >= q.cfg.PerHost {
+		return false
+	}
+	q.hosts[host]++
+	return true
+}
+
+func (q *queueDeliverer) releaseHost(host string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.hosts[host]--
+	if q.hosts[host] <= 0 {
+		delete(q.hosts, host)
+	}
+}
+
+func (q *queueDeliverer) deliveryFile(dir string, d *delivery) string {
+	return filepath.Join(q.cfg.Dir, dir, d.Id+deliveryFileExt)
+}
+
+func (q *queueDeliverer) persist(dir string, d *delivery) error {
+	if len(q.cfg.Dir) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.deliveryFile(dir, d), b)
+}
+
+func (q *queueDeliverer) remove(dir string, d *delivery) {
+	if len(q.cfg.Dir) == 0 {
+		return
+	}
+	if err := os.Remove(q.deliveryFile(dir, d)); err != nil && !os.IsNotExist(err) {
+		log.Printf("cannot remove delivery to %s: %s", d.to, err)
+	}
+}
+
+// load reads the deliveries kept in dir, oldest first.
+func (q *queueDeliverer) load(dir string) ([]*delivery, error) {
+	files, err := ioutil.ReadDir(filepath.Join(q.cfg.Dir, dir))
+	if err != nil {
+		return nil, err
+	}
+	var ds []*delivery
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), deliveryFileExt) || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		name := filepath.Join(q.cfg.Dir, dir, fi.Name())
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		d := &delivery{}
+		if err := json.Unmarshal(b, d); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", name, err)
+		}
+		if d.to, err = url.Parse(d.To); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", name, err)
+		}
+		d.toDo = q.restore
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].Created.Before(ds[j].Created)
+	})
+	return ds, nil
+}
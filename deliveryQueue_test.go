@@ -0,0 +1,191 @@
+package report
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, cfg DeliveryQueueConfig, restore func(b []byte, u *url.URL) error) *queueDeliverer {
+	q, err := newQueueDeliverer(cfg, newDeliveryHistory(10), restore, newBlocklist(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func pendingFiles(t *testing.T, dir, sub string) int {
+	files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestQueueRetriesUntilDelivered(t *testing.T) {
+	q := newTestQueue(t, DeliveryQueueConfig{Backoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}, nil)
+	mu := &sync.Mutex{}
+	attempts := 0
+	delivered := false
+	to, _ := url.Parse("https://peer.example/inbox")
+	q.Do([]byte("{}"), to, func(b []byte, u *url.URL) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts < 3 {
+			return errors.New("unavailable")
+		}
+		delivered = true
+		return nil
+	})
+	waitFor(t, "the delivery", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return delivered
+	})
+	if attempts != 3 {
+		t.Errorf("attempted %d times, want 3", attempts)
+	}
+	if dead := q.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got dead letters %v", dead)
+	}
+}
+
+func TestQueueBackoffBounds(t *testing.T) {
+	q := newTestQueue(t, DeliveryQueueConfig{Backoff: time.Second, MaxBackoff: 10 * time.Second}, nil)
+	for _, test := range []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{40, 10 * time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			if d := q.backoff(test.attempts); d < test.max/2 || d > test.max {
+				t.Errorf("backoff after %d attempts is %s, want between %s and %s", test.attempts, d, test.max/2, test.max)
+				break
+			}
+		}
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestQueue(t, DeliveryQueueConfig{MaxAttempts: 3, Backoff: 5 * time.Millisecond, MaxBackoff: 5 * time.Millisecond, Dir: dir}, nil)
+	mu := &sync.Mutex{}
+	attempts := 0
+	to, _ := url.Parse("https://peer.example/inbox")
+	q.Do([]byte("{}"), to, func(b []byte, u *url.URL) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return errors.New("unavailable")
+	})
+	waitFor(t, "the dead letter", func() bool { return len(q.DeadLetters()) > 0 })
+	dead := q.DeadLetters()
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError != "unavailable" || dead[0].To != to.String() {
+		t.Errorf("got dead letters %+v", dead)
+	}
+	// Let a stray attempt show.
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("attempted %d times, want 3", attempts)
+	}
+	if n := pendingFiles(t, dir, pendingDirName); n != 0 {
+		t.Errorf("%d deliveries still pending on disk", n)
+	}
+	if n := pendingFiles(t, dir, deadDirName); n != 1 {
+		t.Errorf("%d dead letters on disk, want 1", n)
+	}
+}
+
+func TestQueuePerHostLimit(t *testing.T) {
+	q := newTestQueue(t, DeliveryQueueConfig{Workers: 4, PerHost: 2}, nil)
+	mu := &sync.Mutex{}
+	inFlight, most, done := 0, 0, 0
+	to, _ := url.Parse("https://peer.example/inbox")
+	for i := 0; i < 6; i++ {
+		q.Do([]byte("{}"), to, func(b []byte, u *url.URL) error {
+			mu.Lock()
+			if inFlight++; inFlight > most {
+				most = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			done++
+			mu.Unlock()
+			return nil
+		})
+	}
+	waitFor(t, "the deliveries", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return done == 6
+	})
+	if most != 2 {
+		t.Errorf("made %d deliveries to the host at once, want 2", most)
+	}
+}
+
+func TestQueueResumesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	// The first delivery fails, and its retry is far off.
+	failed := make(chan bool, 1)
+	q := newTestQueue(t, DeliveryQueueConfig{Backoff: time.Hour, Dir: dir}, nil)
+	to, _ := url.Parse("https://peer.example/inbox")
+	q.Do([]byte(`{"type": "Note"}`), to, func(b []byte, u *url.URL) error {
+		failed <- true
+		return errors.New("unavailable")
+	})
+	<-failed
+	waitFor(t, "the retry to be persisted", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, pendingDirName, "*"+deliveryFileExt))
+		if len(files) != 1 {
+			return false
+		}
+		d, err := q.load(pendingDirName)
+		return err == nil && len(d) == 1 && d[0].Attempts == 1
+	})
+
+	// A restart resumes it with the restore function.
+	mu := &sync.Mutex{}
+	var resumed []string
+	newTestQueue(t, DeliveryQueueConfig{Dir: dir}, func(b []byte, u *url.URL) error {
+		mu.Lock()
+		defer mu.Unlock()
+		resumed = append(resumed, u.String()+" "+string(b))
+		return nil
+	})
+	waitFor(t, "the resumed delivery", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(resumed) > 0
+	})
+	if want := to.String() + ` {"type": "Note"}`; len(resumed) != 1 || resumed[0] != want {
+		t.Errorf("resumed %v, want %s", resumed, want)
+	}
+	waitFor(t, "the delivery to be removed from disk", func() bool {
+		return pendingFiles(t, dir, pendingDirName) == 0
+	})
+}
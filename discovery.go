@@ -0,0 +1,153 @@
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	webFingerPath     = "/.well-known/webfinger"
+	hostMetaPath      = "/.well-known/host-meta"
+	nodeInfoLinksPath = "/.well-known/nodeinfo"
+	nodeInfoPath      = "/nodeinfo/2.0"
+	nodeInfoSchema    = "http://nodeinfo.diaspora.software/ns/schema/2.0"
+	activityModule    = "github.com/go-fed/activity"
+)
+
+// activityVersion is the version of go-fed/activity built into the program,
+// for builds without module support, such as in a GOPATH. It is set with
+// -ldflags "-X github.com/go-fed/report.activityVersion=v0.4.0".
+var activityVersion string
+
+// ActivityVersion returns the version of go-fed/activity built into the
+// program: activityVersion if set, else the version from the module build
+// info, or "unknown" if it was not built with module support.
+func ActivityVersion() string {
+	if len(activityVersion) > 0 {
+		return activityVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != activityModule {
+			continue
+		} else if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
+
+type webFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+type webFingerResponse struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases"`
+	Links   []webFingerLink `json:"links"`
+}
+
+// serveWebFinger resolves either "acct:name@host" or the id of an actor to the
+// actor, per RFC 7033.
+func (a *app) serveWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if len(resource) == 0 {
+		http.Error(w, "missing resource", http.StatusBadRequest)
+		return
+	}
+	l, ok := a.actorForResource(resource)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, webFingerResponse{
+		Subject: fmt.Sprintf("acct:%s@%s", l.name, a.host),
+		Aliases: []string{l.actorURL.String()},
+		Links: []webFingerLink{
+			{
+				Rel:  "self",
+				Type: activityJSONType,
+				Href: l.actorURL.String(),
+			},
+		},
+	})
+}
+
+// actorForResource finds the actor named by a WebFinger resource.
+func (a *app) actorForResource(resource string) (*localActor, bool) {
+	if strings.HasPrefix(resource, "acct:") {
+		acct := strings.TrimPrefix(resource, "acct:")
+		i := strings.LastIndex(acct, "@")
+		if i < 0 || !strings.EqualFold(acct[i+1:], a.host) {
+			return nil, false
+		}
+		name := acct[:i]
+		return a.findActor(func(l *localActor) bool {
+			return strings.EqualFold(l.name, name)
+		})
+	}
+	u, err := url.Parse(resource)
+	if err != nil {
+		return nil, false
+	}
+	return a.actorFor(u)
+}
+
+// serveHostMeta points to the WebFinger endpoint, for peers that look it up
+// per RFC 6415.
+func (a *app) serveHostMeta(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xrd+xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<XRD xmlns="http://docs.oasis-open.org/ns/xri/xrd-1.0">
+  <Link rel="lrdd" type="application/jrd+json" template="%s://%s%s?resource={uri}"/>
+</XRD>
+`, a.scheme, a.host, webFingerPath)
+}
+
+// serveNodeInfoLinks points to the NodeInfo document.
+func (a *app) serveNodeInfoLinks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"links": []webFingerLink{
+			{
+				Rel:  nodeInfoSchema,
+				Href: fmt.Sprintf("%s://%s%s", a.scheme, a.host, nodeInfoPath),
+			},
+		},
+	})
+}
+
+// serveNodeInfo describes the server as NodeInfo 2.0, reporting the version
+// of go-fed/activity being tested, as given to WithActivityVersion.
+func (a *app) serveNodeInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version": "2.0",
+		"software": map[string]interface{}{
+			"name":    "go-fed",
+			"version": a.activityVersion,
+		},
+		"protocols": []string{"activitypub"},
+		"services": map[string]interface{}{
+			"inbound":  []string{},
+			"outbound": []string{},
+		},
+		"openRegistrations": false,
+		"usage": map[string]interface{}{
+			"users": map[string]interface{}{
+				"total": len(a.actorsSnapshot()),
+			},
+		},
+		"metadata": map[string]interface{}{},
+	})
+}
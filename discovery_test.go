@@ -0,0 +1,34 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityVersionSetAtBuild(t *testing.T) {
+	defer func(v string) { activityVersion = v }(activityVersion)
+	activityVersion = "v0.4.0"
+	if v := ActivityVersion(); v != "v0.4.0" {
+		t.Errorf("got %s, want v0.4.0", v)
+	}
+}
+
+func TestNodeInfoVersion(t *testing.T) {
+	a := newAccessTest(t).app
+	a.activityVersion = "v1.0.0"
+	rec := httptest.NewRecorder()
+	a.serveNodeInfo(rec, httptest.NewRequest(http.MethodGet, nodeInfoPath, nil))
+	var m struct {
+		Software struct {
+			Version string `json:"version"`
+		} `json:"software"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Software.Version != "v1.0.0" {
+		t.Errorf("got %q, want v1.0.0", m.Software.Version)
+	}
+}
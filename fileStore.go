@@ -0,0 +1,254 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/vocab"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	fileStoreObjectsDir = "objects"
+	fileStoreObjectExt  = ".jsonld"
+	fileStoreMetaFile   = "meta.json"
+)
+
+// fileStoreMeta is everything the file store keeps besides the objects.
+type fileStoreMeta struct {
+	NextId int `json:"nextId"`
+}
+
+// NewFileStore returns a Store that keeps every object as a JSON-LD file in
+// dir, alongside a metadata file holding the counter for new ids, so that a
+// report server keeps its history across restarts. Existing files are loaded
+// into memory up front; afterwards every change is written to disk before it
+// is visible to other requests.
+//
+// Files are written to a temporary file and renamed into place, so a crash
+// leaves either the old or the new version of an object but never a partial
+// one.
+func NewFileStore(dir string) (Store, error) {
+	objDir := filepath.Join(dir, fileStoreObjectsDir)
+	if err := os.MkdirAll(objDir, 0700); err != nil {
+		return nil, err
+	}
+	m := newMemoryStore()
+	b, err := ioutil.ReadFile(filepath.Join(dir, fileStoreMetaFile))
+	if err == nil {
+		var meta fileStoreMeta
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", fileStoreMetaFile, err)
+		}
+		if meta.NextId > m.id {
+			m.id = meta.NextId
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(objDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != fileStoreObjectExt {
+			continue
+		}
+		o, err := readObjectFile(filepath.Join(objDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.db[o.GetId().String()] = &lockObj{
+			obj: o,
+			mu:  &sync.RWMutex{},
+		}
+	}
+	log.Printf("loaded %d objects from %s", len(m.db), dir)
+	m.persist = func(o pub.PubObject) error {
+		v, err := o.Serialize()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(objectFileName(objDir, o.GetId()), b)
+	}
+	m.remove = func(id *url.URL) error {
+		err := os.Remove(objectFileName(objDir, id))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return syncDir(objDir)
+	}
+	m.persistId = func(next int) error {
+		b, err := json.Marshal(fileStoreMeta{NextId: next})
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(dir, fileStoreMetaFile), b)
+	}
+	return m, nil
+}
+
+// objectFileName maps an id to a file name safe on any filesystem.
+func objectFileName(dir string, id *url.URL) string {
+	h := sha256.Sum256([]byte(id.String()))
+	return filepath.Join(dir, hex.EncodeToString(h[:])+fileStoreObjectExt)
+}
+
+func readObjectFile(name string) (pub.PubObject, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	}
+	o, err := deserializeObject(m)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	} else if o.GetId() == nil {
+		return nil, fmt.Errorf("reading %s: id is nil", name)
+	}
+	return o, nil
+}
+
+// writeFileAtomic replaces the file name with b such that a crash at any
+// point leaves either the old or the new contents.
+func writeFileAtomic(name string, b []byte) error {
+	dir := filepath.Dir(name)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory so that renames and removals in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// deserializeObject builds the vocab type named by the "type" of m. Types
+// unknown to vocab are deserialized as a plain Object.
+func deserializeObject(m map[string]interface{}) (pub.PubObject, error) {
+	var o vocab.ObjectType = &vocab.Object{}
+	for _, t := range typeNames(m["type"]) {
+		if fn, ok := vocabTypes[t]; ok {
+			o = fn()
+			break
+		}
+	}
+	if err := o.Deserialize(m); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// typeNames returns the type names of a JSON-LD "type" value, which is either
+// a single string or an array of them.
+func typeNames(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{strings.TrimPrefix(t, "as:")}
+	case []interface{}:
+		var names []string
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				names = append(names, strings.TrimPrefix(s, "as:"))
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+var vocabTypes = map[string]func() vocab.ObjectType{
+	"Object":                func() vocab.ObjectType { return &vocab.Object{} },
+	"Activity":              func() vocab.ObjectType { return &vocab.Activity{} },
+	"IntransitiveActivity":  func() vocab.ObjectType { return &vocab.IntransitiveActivity{} },
+	"Collection":            func() vocab.ObjectType { return &vocab.Collection{} },
+	"OrderedCollection":     func() vocab.ObjectType { return &vocab.OrderedCollection{} },
+	"CollectionPage":        func() vocab.ObjectType { return &vocab.CollectionPage{} },
+	"OrderedCollectionPage": func() vocab.ObjectType { return &vocab.OrderedCollectionPage{} },
+	"Accept":                func() vocab.ObjectType { return &vocab.Accept{} },
+	"TentativeAccept":       func() vocab.ObjectType { return &vocab.TentativeAccept{} },
+	"Add":                   func() vocab.ObjectType { return &vocab.Add{} },
+	"Arrive":                func() vocab.ObjectType { return &vocab.Arrive{} },
+	"Create":                func() vocab.ObjectType { return &vocab.Create{} },
+	"Delete":                func() vocab.ObjectType { return &vocab.Delete{} },
+	"Follow":                func() vocab.ObjectType { return &vocab.Follow{} },
+	"Ignore":                func() vocab.ObjectType { return &vocab.Ignore{} },
+	"Join":                  func() vocab.ObjectType { return &vocab.Join{} },
+	"Leave":                 func() vocab.ObjectType { return &vocab.Leave{} },
+	"Like":                  func() vocab.ObjectType { return &vocab.Like{} },
+	"Offer":                 func() vocab.ObjectType { return &vocab.Offer{} },
+	"Invite":                func() vocab.ObjectType { return &vocab.Invite{} },
+	"Reject":                func() vocab.ObjectType { return &vocab.Reject{} },
+	"TentativeReject":       func() vocab.ObjectType { return &vocab.TentativeReject{} },
+	"Remove":                func() vocab.ObjectType { return &vocab.Remove{} },
+	"Undo":                  func() vocab.ObjectType { return &vocab.Undo{} },
+	"Update":                func() vocab.ObjectType { return &vocab.Update{} },
+	"View":                  func() vocab.ObjectType { return &vocab.View{} },
+	"Listen":                func() vocab.ObjectType { return &vocab.Listen{} },
+	"Read":                  func() vocab.ObjectType { return &vocab.Read{} },
+	"Move":                  func() vocab.ObjectType { return &vocab.Move{} },
+	"Travel":                func() vocab.ObjectType { return &vocab.Travel{} },
+	"Announce":              func() vocab.ObjectType { return &vocab.Announce{} },
+	"Block":                 func() vocab.ObjectType { return &vocab.Block{} },
+	"Flag":                  func() vocab.ObjectType { return &vocab.Flag{} },
+	"Dislike":               func() vocab.ObjectType { return &vocab.Dislike{} },
+	"Question":              func() vocab.ObjectType { return &vocab.Question{} },
+	"Application":           func() vocab.ObjectType { return &vocab.Application{} },
+	"Group":                 func() vocab.ObjectType { return &vocab.Group{} },
+	"Organization":          func() vocab.ObjectType { return &vocab.Organization{} },
+	"Person":                func() vocab.ObjectType { return &vocab.Person{} },
+	"Service":               func() vocab.ObjectType { return &vocab.Service{} },
+	"Relationship":          func() vocab.ObjectType { return &vocab.Relationship{} },
+	"Article":               func() vocab.ObjectType { return &vocab.Article{} },
+	"Document":              func() vocab.ObjectType { return &vocab.Document{} },
+	"Audio":                 func() vocab.ObjectType { return &vocab.Audio{} },
+	"Image":                 func() vocab.ObjectType { return &vocab.Image{} },
+	"Video":                 func() vocab.ObjectType { return &vocab.Video{} },
+	"Note":                  func() vocab.ObjectType { return &vocab.Note{} },
+	"Page":                  func() vocab.ObjectType { return &vocab.Page{} },
+	"Event":                 func() vocab.ObjectType { return &vocab.Event{} },
+	"Place":                 func() vocab.ObjectType { return &vocab.Place{} },
+	"Profile":               func() vocab.ObjectType { return &vocab.Profile{} },
+	"Tombstone":             func() vocab.ObjectType { return &vocab.Tombstone{} },
+}
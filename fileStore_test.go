@@ -0,0 +1,125 @@
+package report
+
+import (
+	"context"
+	"github.com/go-fed/activity/pub"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openFileStore(t *testing.T, dir string) Store {
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	kept, _ := url.Parse("https://example.com/new/1")
+	deleted, _ := url.Parse("https://example.com/new/2")
+	for _, id := range []*url.URL{kept, deleted} {
+		if err := s.Put(context.Background(), mapObject{"id": id.String(), "type": "Note", "content": "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete(context.Background(), deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openFileStore(t, dir)
+	o, err := s.Get(context.Background(), kept, pub.Read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := o.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stringValue(m["id"]) != kept.String() || stringValue(m["content"]) != "hello" {
+		t.Errorf("got %v", m)
+	}
+	if has, err := s.Has(context.Background(), deleted); err != nil || has {
+		t.Errorf("deleted object is back: %v, %v", has, err)
+	}
+}
+
+func TestFileStoreLocks(t *testing.T) {
+	s := openFileStore(t, t.TempDir())
+	id, _ := url.Parse("https://example.com/new/1")
+	if err := s.Put(context.Background(), mapObject{"id": id.String(), "type": "Note"}); err != nil {
+		t.Fatal(err)
+	}
+	c1, cancel1 := lockedContext(1)
+	defer cancel1()
+	o, err := s.Get(c1, id, pub.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second writer waits for the first to put the object back.
+	got := make(chan error, 1)
+	go func() {
+		c2, cancel2 := lockedContext(2)
+		defer cancel2()
+		_, err := s.Get(c2, id, pub.ReadWrite)
+		got <- err
+	}()
+	select {
+	case <-got:
+		t.Fatal("locked object got for writing twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := s.Put(c1, o); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("object still locked once put")
+	}
+}
+
+func TestFileStoreNextIdAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	var last int
+	for i := 0; i < 2; i++ {
+		var err error
+		if last, err = s.NextId(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next, err := openFileStore(t, dir).NextId(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if next != last+1 {
+		t.Errorf("got id %d after restart, want %d", next, last+1)
+	}
+}
+
+func TestFileStoreCorruptFile(t *testing.T) {
+	for _, name := range []string{
+		filepath.Join(fileStoreObjectsDir, "corrupt"+fileStoreObjectExt),
+		fileStoreMetaFile,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			openFileStore(t, dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(`{"id": `), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewFileStore(dir); err == nil {
+				t.Error("opened a store with a corrupt file")
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/vocab"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const adminFollowsPath = "/admin/follows"
+
+const (
+	// FollowsAccept accepts every Follow, FollowsReject rejects them all,
+	// and FollowsManual holds them until an administrator decides.
+	FollowsAccept = "accept"
+	FollowsReject = "reject"
+	FollowsManual = "manual"
+)
+
+// validFollowPolicy determines whether policy is one of the follow policies.
+func validFollowPolicy(policy string) bool {
+	return policy == FollowsAccept || policy == FollowsReject || policy == FollowsManual
+}
+
+// pendingFollow is a Follow of a hosted actor awaiting a decision.
+type pendingFollow struct {
+	Id       int                    `json:"id"`
+	Received time.Time              `json:"received"`
+	Actor    string                 `json:"actor"`
+	Object   string                 `json:"object"`
+	Follow   map[string]interface{} `json:"follow"`
+}
+
+// followQueue holds the Follows received under FollowsManual. Each change is
+// passed to save, if set, while the queue is locked, so saved copies are
+// written in order.
+type followQueue struct {
+	pending []pendingFollow
+	next    int
+	mu      *sync.Mutex
+	save    func(pending []pendingFollow)
+}
+
+func newFollowQueue() *followQueue {
+	return &followQueue{
+		next: 1,
+		mu:   &sync.Mutex{},
+	}
+}
+
+// changed saves the pending Follows. It must be called with mu held.
+func (q *followQueue) changed() {
+	if q.save != nil {
+		q.save(append([]pendingFollow{}, q.pending...))
+	}
+}
+
+// restore holds the Follows of a saved queue, without saving them again.
+func (q *followQueue) restore(pending []pendingFollow) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, pending...)
+	for _, p := range pending {
+		if p.Id >= q.next {
+			q.next = p.Id + 1
+		}
+	}
+}
+
+func (q *followQueue) add(follow map[string]interface{}, received time.Time) pendingFollow {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := pendingFollow{
+		Id:       q.next,
+		Received: received,
+		Actor:    actorId(follow["actor"]),
+		Object:   actorId(follow["object"]),
+		Follow:   follow,
+	}
+	q.next++
+	q.pending = append(q.pending, p)
+	q.changed()
+	return p
+}
+
+// list returns the pending Follows, oldest first.
+func (q *followQueue) list() []pendingFollow {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]pendingFollow{}, q.pending...)
+}
+
+// take removes the pending Follow with the given id to decide it, so it is
+// decided only once.
+func (q *followQueue) take(id int) (pendingFollow, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p.Id == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.changed()
+			return p, true
+		}
+	}
+	return pendingFollow{}, false
+}
+
+// putBack holds a taken Follow again, in its place, when deciding it failed.
+func (q *followQueue) putBack(p pendingFollow) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := 0
+	for i < len(q.pending) && q.pending[i].Id < p.Id {
+		i++
+	}
+	q.pending = append(q.pending, pendingFollow{})
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = p
+	q.changed()
+}
+
+// pendingFollowsURL is the id under which the pending Follows are stored.
+func (a *app) pendingFollowsURL() *url.URL {
+	return &url.URL{Scheme: a.scheme, Host: a.host, Path: adminFollowsPath}
+}
+
+// savePendingFollows stores the pending Follows as a collection, so they
+// survive restarts with a durable Store. Only the Follows are kept: the time
+// they were received is that of the restart once restored.
+func (a *app) savePendingFollows(pending []pendingFollow) {
+	items := make([]interface{}, 0, len(pending))
+	for _, p := range pending {
+		items = append(items, p.Follow)
+	}
+	oc := &vocab.OrderedCollection{}
+	err := oc.Deserialize(map[string]interface{}{
+		"id":           a.pendingFollowsURL().String(),
+		"type":         "OrderedCollection",
+		"orderedItems": items,
+	})
+	if err == nil {
+		err = a.store.Put(context.Background(), oc)
+	}
+	if err != nil {
+		log.Printf("cannot store pending follows: %s", err)
+	}
+}
+
+// restorePendingFollows holds the Follows stored by savePendingFollows again.
+func (a *app) restorePendingFollows(c context.Context) error {
+	id := a.pendingFollowsURL()
+	if has, err := a.store.Has(c, id); err != nil || !has {
+		return err
+	}
+	oc, err := a.getCollection(c, id)
+	if err != nil {
+		return err
+	}
+	m, err := oc.Serialize()
+	if err != nil {
+		return err
+	}
+	var pending []pendingFollow
+	for i, item := range orderedItems(m) {
+		follow, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pending = append(pending, pendingFollow{
+			Id:       i + 1,
+			Received: a.clock.Now(),
+			Actor:    actorId(follow["actor"]),
+			Object:   actorId(follow["object"]),
+			Follow:   follow,
+		})
+	}
+	if len(pending) > 0 {
+		log.Printf("restored %d pending follows", len(pending))
+	}
+	a.follows.restore(pending)
+	return nil
+}
+
+func (a *app) OnFollow(c context.Context, s *streams.Follow) pub.FollowResponse {
+	switch a.followPolicy {
+	case FollowsReject:
+		return pub.AutomaticReject
+	case FollowsManual:
+		m, err := s.Raw().Serialize()
+		if err != nil {
+			log.Printf("cannot hold Follow, rejecting it: %s", err)
+			return pub.AutomaticReject
+		}
+		p := a.follows.add(m, a.clock.Now())
+		log.Printf("holding Follow %d of %s by %s", p.Id, p.Object, p.Actor)
+		return pub.DoNothing
+	default:
+		return pub.AutomaticAccept
+	}
+}
+
+// decideFollow accepts or rejects the pending Follow with the given id: the
+// follower is added to the followers of the followed actor if accepted, and
+// sent an Accept or Reject of the Follow, which is added to the outbox of the
+// followed actor. The Follow is taken from the queue while deciding, so it is
+// not decided twice at once, and stays pending if deciding fails.
+func (a *app) decideFollow(c context.Context, id int, accept bool) (m map[string]interface{}, err error) {
+	p, ok := a.follows.take(id)
+	if !ok {
+		return nil, fmt.Errorf("no pending follow %d", id)
+	}
+	defer func() {
+		if err != nil {
+			a.follows.putBack(p)
+		}
+	}()
+	objectIRI, err := url.Parse(p.Object)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := a.actorFor(objectIRI)
+	if !ok {
+		return nil, fmt.Errorf("follow %d is of %q, who is not hosted here", id, p.Object)
+	}
+	follower, err := url.Parse(p.Actor)
+	if err != nil {
+		return nil, err
+	}
+	inbox, err := a.inboxOf(c, follower)
+	if err != nil {
+		return nil, err
+	}
+	if accept {
+		if err := a.addFollower(c, l, follower); err != nil {
+			return nil, err
+		}
+	}
+	t, verb := "Reject", "rejecting"
+	if accept {
+		t, verb = "Accept", "accepting"
+	}
+	m = map[string]interface{}{
+		"@context": activityStreamsContext,
+		"id":       a.NewId(c, nil).String(),
+		"type":     t,
+		"actor":    l.actorURL.String(),
+		"object":   p.Follow,
+		"to":       []interface{}{p.Actor},
+	}
+	o, err := deserializeObject(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Set(c, o); err != nil {
+		return nil, err
+	}
+	if err := a.addToOutbox(c, l, o.GetId()); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s Follow %d of %s by %s", verb, id, p.Object, p.Actor)
+	a.deliverer.Do(b, inbox, a.deliver)
+	return m, nil
+}
+
+// addToOutbox puts the activity id first in the outbox of l, as go-fed/activity
+// does with the activities posted to it.
+func (a *app) addToOutbox(c context.Context, l *localActor, id *url.URL) error {
+	o, err := a.store.Get(c, l.outboxURL, pub.ReadWrite)
+	if err != nil {
+		return err
+	}
+	oc, ok := o.(vocab.OrderedCollectionType)
+	if !ok {
+		return fmt.Errorf("%s is not an OrderedCollectionType", l.outboxURL)
+	}
+	oc.PrependOrderedItemsIRI(id)
+	return a.Set(c, oc)
+}
+
+// addFollower adds follower to the followers of l, unless already there.
+func (a *app) addFollower(c context.Context, l *localActor, follower *url.URL) error {
+	o, err := a.store.Get(c, l.followersURL, pub.ReadWrite)
+	if err != nil {
+		return err
+	}
+	oc, ok := o.(vocab.OrderedCollectionType)
+	if !ok {
+		return fmt.Errorf("%s is not an OrderedCollectionType", l.followersURL)
+	}
+	for i := 0; i < oc.OrderedItemsLen(); i++ {
+		if oc.IsOrderedItemsIRI(i) && *oc.GetOrderedItemsIRI(i) == *follower {
+			return a.store.Put(c, oc)
+		}
+	}
+	oc.AppendOrderedItemsIRI(follower)
+	return a.Set(c, oc)
+}
+
+// inboxOf returns the inbox of the actor id, fetching remote actors.
+func (a *app) inboxOf(c context.Context, id *url.URL) (*url.URL, error) {
+	if l, ok := a.actorFor(id); ok {
+		return l.inboxURL, nil
+	}
+	m, err := a.keys.fetchJSON(c, id)
+	if err != nil {
+		return nil, err
+	}
+	inbox := stringValue(m["inbox"])
+	if len(inbox) == 0 {
+		return nil, fmt.Errorf("%s has no inbox", id)
+	}
+	return url.Parse(inbox)
+}
+
+// followDecision is what administrators POST to accept or reject a Follow.
+type followDecision struct {
+	Id     int  `json:"id"`
+	Accept bool `json:"accept"`
+}
+
+// serveAdminFollows lists the pending Follows on GET, and accepts or rejects
+// one given a JSON encoded followDecision on POST, returning the Accept or
+// Reject sent.
+func (a *app) serveAdminFollows(c context.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.follows.list())
+	case http.MethodPost:
+		var d followDecision
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := a.decideFollow(c, d.Id, d.Accept)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot decide follow: %s", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,132 @@
+package report
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingDeliverer counts the deliveries it is given instead of making them.
+type countingDeliverer struct {
+	to []string
+	mu *sync.Mutex
+}
+
+func (d *countingDeliverer) Do(b []byte, to *url.URL, toDo func(b []byte, u *url.URL) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.to = append(d.to, to.String())
+}
+
+func (d *countingDeliverer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.to)
+}
+
+// newFollowTest holds a Follow of alice by bob, deliveries being counted
+// instead of made.
+func newFollowTest(t *testing.T) (*accessTest, *countingDeliverer, pendingFollow) {
+	at := newAccessTest(t)
+	d := &countingDeliverer{mu: &sync.Mutex{}}
+	at.app.deliverer = d
+	at.app.follows.save = at.app.savePendingFollows
+	p := at.app.follows.add(map[string]interface{}{
+		"id":     at.bob.ActorIRI().String() + "/follows/1",
+		"type":   "Follow",
+		"actor":  at.bob.ActorIRI().String(),
+		"object": at.alice.actorURL.String(),
+	}, at.app.clock.Now())
+	return at, d, p
+}
+
+func lockedContext(key int) (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.WithValue(context.Background(), lockKeyName, key))
+}
+
+func TestDecideFollowOnce(t *testing.T) {
+	at, d, p := newFollowTest(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			c, cancel := lockedContext(1000 + key)
+			defer cancel()
+			_, err := at.app.decideFollow(c, p.Id, true)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 1 || d.count() != 1 {
+		t.Errorf("%d decisions failed and %d Accepts were sent, want 1 and 1", failed, d.count())
+	}
+	if n := len(at.app.follows.list()); n != 0 {
+		t.Errorf("%d follows still pending", n)
+	}
+
+	// The Accept is first in alice's outbox.
+	oc, err := at.app.getCollection(context.Background(), at.alice.outboxURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := oc.Serialize()
+	items := orderedItems(m)
+	if len(items) != 1 {
+		t.Fatalf("outbox holds %v", items)
+	}
+	accept, ok := at.app.storedJSON(context.Background(), stringValue(items[0]))
+	if !ok || stringValue(accept["type"]) != "Accept" {
+		t.Errorf("outbox holds %v", accept)
+	}
+}
+
+func TestDecideFollowPutBackOnFailure(t *testing.T) {
+	at, d, p := newFollowTest(t)
+	// The inbox of a second follower cannot be found.
+	p2 := at.app.follows.add(map[string]interface{}{
+		"id":     "http://unreachable.invalid/follows/1",
+		"type":   "Follow",
+		"actor":  "http://unreachable.invalid/users/mallory",
+		"object": at.alice.actorURL.String(),
+	}, at.app.clock.Now())
+	c, cancel := lockedContext(1)
+	defer cancel()
+	if _, err := at.app.decideFollow(c, p2.Id, true); err == nil {
+		t.Fatal("decided a follow by an unreachable actor")
+	}
+	pending := at.app.follows.list()
+	if len(pending) != 2 || pending[0].Id != p.Id || pending[1].Id != p2.Id {
+		t.Errorf("pending follows are %v", pending)
+	}
+	if d.count() != 0 {
+		t.Errorf("sent %d activities", d.count())
+	}
+}
+
+func TestPendingFollowsRestored(t *testing.T) {
+	at, _, p := newFollowTest(t)
+	// A restart keeps the store but not the queue.
+	at.app.follows = newFollowQueue()
+	if err := at.app.restorePendingFollows(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pending := at.app.follows.list()
+	if len(pending) != 1 || pending[0].Actor != p.Actor || pending[0].Object != p.Object {
+		t.Fatalf("restored %v, want %v", pending, p)
+	}
+	next := at.app.follows.add(map[string]interface{}{"actor": p.Actor, "object": p.Object}, time.Now())
+	if next.Id == pending[0].Id {
+		t.Errorf("reused id %d", next.Id)
+	}
+}
@@ -0,0 +1,159 @@
+package report
+
+import (
+	"context"
+	"github.com/go-fed/activity/vocab"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// maxForwardingDepth bounds how deep embedded objects are searched for
+	// one owned by the server.
+	maxForwardingDepth = 3
+	// defaultForwardingLimit is how many activities of one actor are
+	// forwarded per forwardingWindow.
+	defaultForwardingLimit = 30
+	forwardingWindow       = time.Minute
+)
+
+// referenceProperties are the properties of an activity that must lead to an
+// object owned by the server for it to be forwarded.
+var referenceProperties = []string{"inReplyTo", "object", "target", "tag"}
+
+// forwardingRecipientProperties are the addressing properties that must hold
+// a collection owned by the server for an activity to be forwarded.
+var forwardingRecipientProperties = []string{"to", "cc", "audience"}
+
+// forwardingLimiter bounds how many activities of each actor are forwarded in
+// a sliding window.
+type forwardingLimiter struct {
+	limit     int
+	window    time.Duration
+	forwarded map[string][]time.Time
+	mu        *sync.Mutex
+}
+
+func newForwardingLimiter(limit int, window time.Duration) *forwardingLimiter {
+	if limit <= 0 {
+		limit = defaultForwardingLimit
+	}
+	return &forwardingLimiter{
+		limit:     limit,
+		window:    window,
+		forwarded: make(map[string][]time.Time),
+		mu:        &sync.Mutex{},
+	}
+}
+
+// allow determines whether an activity of actor may be forwarded at now, and
+// counts it if so. Actors with nothing forwarded within the window are
+// forgotten, so the limiter only remembers the actors active lately.
+func (f *forwardingLimiter) allow(actor string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for a, times := range f.forwarded {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= f.window {
+			delete(f.forwarded, a)
+		}
+	}
+	var recent []time.Time
+	for _, t := range f.forwarded[actor] {
+		if now.Sub(t) < f.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= f.limit {
+		f.forwarded[actor] = recent
+		return false
+	}
+	f.forwarded[actor] = append(recent, now)
+	return true
+}
+
+// FilterForwarding implements the inbox forwarding of section 7.1.2 of
+// ActivityPub: an activity is only forwarded if it is addressed to a collection
+// owned by the server, and its inReplyTo, object, target or tag lead to an
+// object owned by the server. The activities of each actor are forwarded a
+// limited number of times a minute. With permissiveForwarding, everything is
+// forwarded instead.
+func (a *app) FilterForwarding(c context.Context, activity vocab.ActivityType, iris []*url.URL) ([]*url.URL, error) {
+	if a.permissiveForwarding {
+		// Do NOT do this in real implementations. This turns the server
+		// into a spambot. See the documentation in go-fed/activity/pub.
+		log.Printf("forwarding %s to %d recipients permissively", activity.GetId(), len(iris))
+		return iris, nil
+	}
+	m, err := activity.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if !a.addressesOwnedCollection(m) {
+		log.Printf("not forwarding %s: not addressed to a collection of ours", activity.GetId())
+		return nil, nil
+	}
+	if !a.referencesOwnedObject(c, m, maxForwardingDepth) {
+		log.Printf("not forwarding %s: references no object of ours", activity.GetId())
+		return nil, nil
+	}
+	actor := actorId(m["actor"])
+	if !a.forwarding.allow(actor, a.clock.Now()) {
+		log.Printf("not forwarding %s: %s is over the forwarding limit", activity.GetId(), actor)
+		return nil, nil
+	}
+	log.Printf("forwarding %s of %s to %d recipients", activity.GetId(), actor, len(iris))
+	return iris, nil
+}
+
+// addressesOwnedCollection determines whether the serialized activity m is
+// addressed to a collection of a hosted actor.
+func (a *app) addressesOwnedCollection(m map[string]interface{}) bool {
+	for _, p := range forwardingRecipientProperties {
+		for _, id := range ids(m[p]) {
+			if u, err := url.Parse(id); err == nil && a.isCollection(u) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referencesOwnedObject determines whether the reference properties of the
+// serialized object m lead to an object owned by the server, searching
+// embedded objects up to depth deep.
+func (a *app) referencesOwnedObject(c context.Context, m map[string]interface{}, depth int) bool {
+	if depth <= 0 {
+		return false
+	}
+	for _, p := range referenceProperties {
+		for _, id := range ids(m[p]) {
+			if u, err := url.Parse(id); err == nil && a.Owns(c, u) {
+				return true
+			}
+		}
+		for _, e := range embedded(m[p]) {
+			if a.referencesOwnedObject(c, e, depth-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// embedded returns the objects embedded in a property that may hold an IRI,
+// an object or an array of either.
+func embedded(v interface{}) []map[string]interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{t}
+	case []interface{}:
+		var ms []map[string]interface{}
+		for _, e := range t {
+			ms = append(ms, embedded(e)...)
+		}
+		return ms
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package report
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestForwardingLimiter(t *testing.T) {
+	f := newForwardingLimiter(2, time.Minute)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, step := range []struct {
+		actor string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, true},
+		{"a", time.Second, true},
+		{"a", 2 * time.Second, false},
+		{"b", 2 * time.Second, true},
+		{"a", time.Minute, true},
+		{"a", time.Minute + 500*time.Millisecond, false},
+	} {
+		if got := f.allow(step.actor, start.Add(step.after)); got != step.want {
+			t.Errorf("%s after %s: got %v, want %v", step.actor, step.after, got, step.want)
+		}
+	}
+}
+
+func TestForwardingLimiterForgetsIdleActors(t *testing.T) {
+	f := newForwardingLimiter(2, time.Minute)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1000; i++ {
+		f.allow(fmt.Sprintf("https://example.com/users/%d", i), start.Add(time.Duration(i)*time.Second))
+	}
+	// Only the actors seen within the last minute are remembered.
+	if n := len(f.forwarded); n > 61 {
+		t.Errorf("remembers %d actors", n)
+	}
+}
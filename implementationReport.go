@@ -0,0 +1,367 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReportSubject    = "go-fed/activity"
+	defaultReportSubjectURL = "https://github.com/go-fed/activity"
+	defaultReportAssertor   = "https://github.com/go-fed/report"
+	earlNamespace           = "http://www.w3.org/ns/earl#"
+	doapNamespace           = "http://usefulinc.com/ns/doap#"
+	dctermsNamespace        = "http://purl.org/dc/terms/"
+	xsdNamespace            = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// reportLevels orders the requirement levels within a section.
+var reportLevels = []string{"MUST", "SHOULD", "MAY"}
+
+// ImplementationReport is the implementation report made from the results of
+// a test run, grouped by section of the specification and by level of
+// requirement within each section.
+type ImplementationReport struct {
+	// Subject is the name of the implementation under test, and
+	// SubjectURL its homepage.
+	Subject    string
+	SubjectURL string
+	// Version is the version of the implementation under test.
+	Version string
+	// Assertor is the IRI of the tool that ran the tests.
+	Assertor string
+	// TestBase prefixes the names of test cases to make their IRIs.
+	TestBase string
+	Date     time.Time
+	Sections []ReportSection
+}
+
+// ReportSection holds the results for one section of the specification.
+type ReportSection struct {
+	Section string
+	Levels  []ReportLevel
+}
+
+// ReportLevel holds the results for one level of requirement.
+type ReportLevel struct {
+	Level   string
+	Results []SelfTestResult
+}
+
+// NewImplementationReport groups results into a report on the given version
+// of go-fed/activity, that of the tested server, or "unknown" if empty.
+func NewImplementationReport(version string, results []SelfTestResult) *ImplementationReport {
+	if len(version) == 0 {
+		version = "unknown"
+	}
+	r := &ImplementationReport{
+		Subject:    defaultReportSubject,
+		SubjectURL: defaultReportSubjectURL,
+		Version:    version,
+		Assertor:   defaultReportAssertor,
+		TestBase:   defaultReportAssertor + "#",
+		Date:       time.Now().UTC(),
+	}
+	bySection := make(map[string]map[string][]SelfTestResult)
+	for _, res := range results {
+		levels, ok := bySection[res.Case.Section]
+		if !ok {
+			levels = make(map[string][]SelfTestResult)
+			bySection[res.Case.Section] = levels
+		}
+		levels[res.Case.Level] = append(levels[res.Case.Level], res)
+	}
+	for section, levels := range bySection {
+		s := ReportSection{Section: section}
+		for level, res := range levels {
+			s.Levels = append(s.Levels, ReportLevel{Level: level, Results: res})
+		}
+		sort.Slice(s.Levels, func(i, j int) bool {
+			return levelRank(s.Levels[i].Level) < levelRank(s.Levels[j].Level)
+		})
+		r.Sections = append(r.Sections, s)
+	}
+	sort.Slice(r.Sections, func(i, j int) bool {
+		return sectionLess(r.Sections[i].Section, r.Sections[j].Section)
+	})
+	return r
+}
+
+// levelRank orders MUST before SHOULD before MAY, and anything else last.
+func levelRank(level string) int {
+	for i, l := range reportLevels {
+		if strings.EqualFold(l, level) {
+			return i
+		}
+	}
+	return len(reportLevels)
+}
+
+// sectionLess compares section numbers such as "6.10" and "6.9" numerically.
+func sectionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr != nil || bErr != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+		} else if an != bn {
+			return an < bn
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// outcome returns the EARL outcome of a result.
+func outcome(r SelfTestResult) string {
+	if r.Skipped {
+		return "untested"
+	} else if r.Passed {
+		return "passed"
+	}
+	return "failed"
+}
+
+// reportCount is the number of results of each outcome.
+type reportCount struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+func (c *reportCount) add(r SelfTestResult) {
+	if r.Skipped {
+		c.Skipped++
+	} else if r.Passed {
+		c.Passed++
+	} else {
+		c.Failed++
+	}
+}
+
+// totals counts the results of the whole report.
+func (r *ImplementationReport) totals() reportCount {
+	var c reportCount
+	for _, s := range r.Sections {
+		for _, l := range s.Levels {
+			for _, res := range l.Results {
+				c.add(res)
+			}
+		}
+	}
+	return c
+}
+
+func (r *ImplementationReport) testIRI(res SelfTestResult) string {
+	return r.TestBase + res.Case.Name
+}
+
+// WriteEARLTurtle writes the report as EARL assertions in Turtle.
+func (r *ImplementationReport) WriteEARLTurtle(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@prefix earl: <%s> .\n", earlNamespace)
+	fmt.Fprintf(&b, "@prefix doap: <%s> .\n", doapNamespace)
+	fmt.Fprintf(&b, "@prefix dcterms: <%s> .\n", dctermsNamespace)
+	fmt.Fprintf(&b, "@prefix xsd: <%s> .\n\n", xsdNamespace)
+	fmt.Fprintf(&b, "<%s> a doap:Project, earl:TestSubject ;\n", r.SubjectURL)
+	fmt.Fprintf(&b, "  doap:name %s ;\n", turtleString(r.Subject))
+	fmt.Fprintf(&b, "  doap:release [ doap:revision %s ] .\n\n", turtleString(r.Version))
+	fmt.Fprintf(&b, "<%s> a earl:Assertor, earl:Software .\n", r.Assertor)
+	date := r.Date.Format(time.RFC3339)
+	for _, s := range r.Sections {
+		for _, l := range s.Levels {
+			for _, res := range l.Results {
+				fmt.Fprintf(&b, "\n<%s> a earl:TestCase ;\n", r.testIRI(res))
+				fmt.Fprintf(&b, "  dcterms:title %s ;\n", turtleString(res.Case.Name))
+				fmt.Fprintf(&b, "  dcterms:description %s ;\n", turtleString(fmt.Sprintf("%s %s: %s", res.Case.Section, res.Case.Level, res.Case.Requirement)))
+				fmt.Fprintf(&b, "  dcterms:isPartOf %s .\n\n", turtleString(res.Case.Section))
+				fmt.Fprintf(&b, "[] a earl:Assertion ;\n")
+				fmt.Fprintf(&b, "  earl:assertedBy <%s> ;\n", r.Assertor)
+				fmt.Fprintf(&b, "  earl:subject <%s> ;\n", r.SubjectURL)
+				fmt.Fprintf(&b, "  earl:test <%s> ;\n", r.testIRI(res))
+				fmt.Fprintf(&b, "  earl:mode earl:automatic ;\n")
+				fmt.Fprintf(&b, "  earl:result [\n")
+				fmt.Fprintf(&b, "    a earl:TestResult ;\n")
+				fmt.Fprintf(&b, "    earl:outcome earl:%s ;\n", outcome(res))
+				if len(res.Error) > 0 {
+					fmt.Fprintf(&b, "    earl:info %s ;\n", turtleString(res.Error))
+				}
+				fmt.Fprintf(&b, "    dcterms:date %s^^xsd:dateTime\n", turtleString(date))
+				fmt.Fprintf(&b, "  ] .\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// turtleString quotes s as a Turtle string literal.
+func turtleString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// WriteEARLJSONLD writes the report as EARL assertions in JSON-LD.
+func (r *ImplementationReport) WriteEARLJSONLD(w io.Writer) error {
+	date := r.Date.Format(time.RFC3339)
+	graph := []interface{}{
+		map[string]interface{}{
+			"@id":       r.SubjectURL,
+			"@type":     []string{"doap:Project", "earl:TestSubject"},
+			"doap:name": r.Subject,
+			"doap:release": map[string]interface{}{
+				"doap:revision": r.Version,
+			},
+		},
+		map[string]interface{}{
+			"@id":   r.Assertor,
+			"@type": []string{"earl:Assertor", "earl:Software"},
+		},
+	}
+	for _, s := range r.Sections {
+		for _, l := range s.Levels {
+			for _, res := range l.Results {
+				result := map[string]interface{}{
+					"@type":        "earl:TestResult",
+					"earl:outcome": map[string]string{"@id": "earl:" + outcome(res)},
+					"dcterms:date": map[string]string{"@value": date, "@type": "xsd:dateTime"},
+				}
+				if len(res.Error) > 0 {
+					result["earl:info"] = res.Error
+				}
+				graph = append(graph, map[string]interface{}{
+					"@type":           "earl:Assertion",
+					"earl:assertedBy": map[string]string{"@id": r.Assertor},
+					"earl:subject":    map[string]string{"@id": r.SubjectURL},
+					"earl:test": map[string]interface{}{
+						"@id":                 r.testIRI(res),
+						"@type":               "earl:TestCase",
+						"dcterms:title":       res.Case.Name,
+						"dcterms:description": fmt.Sprintf("%s %s: %s", res.Case.Section, res.Case.Level, res.Case.Requirement),
+						"dcterms:isPartOf":    res.Case.Section,
+					},
+					"earl:mode":   map[string]string{"@id": "earl:automatic"},
+					"earl:result": result,
+				})
+			}
+		}
+	}
+	return writeIndentedJSON(w, map[string]interface{}{
+		"@context": map[string]string{
+			"earl":    earlNamespace,
+			"doap":    doapNamespace,
+			"dcterms": dctermsNamespace,
+			"xsd":     xsdNamespace,
+		},
+		"@graph": graph,
+	})
+}
+
+type reportSummaryResult struct {
+	Name        string `json:"name"`
+	Requirement string `json:"requirement"`
+	Outcome     string `json:"outcome"`
+	Error       string `json:"error,omitempty"`
+}
+
+type reportSummaryLevel struct {
+	Level string `json:"level"`
+	reportCount
+	Results []reportSummaryResult `json:"results"`
+}
+
+type reportSummarySection struct {
+	Section string               `json:"section"`
+	Levels  []reportSummaryLevel `json:"levels"`
+}
+
+type reportSummary struct {
+	Subject  string                 `json:"subject"`
+	Version  string                 `json:"version"`
+	Date     time.Time              `json:"date"`
+	Totals   reportCount            `json:"totals"`
+	Sections []reportSummarySection `json:"sections"`
+}
+
+func (r *ImplementationReport) summary() reportSummary {
+	s := reportSummary{
+		Subject: r.Subject,
+		Version: r.Version,
+		Date:    r.Date,
+		Totals:  r.totals(),
+	}
+	for _, sec := range r.Sections {
+		ss := reportSummarySection{Section: sec.Section}
+		for _, l := range sec.Levels {
+			sl := reportSummaryLevel{Level: l.Level}
+			for _, res := range l.Results {
+				sl.add(res)
+				sl.Results = append(sl.Results, reportSummaryResult{
+					Name:        res.Case.Name,
+					Requirement: res.Case.Requirement,
+					Outcome:     outcome(res),
+					Error:       res.Error,
+				})
+			}
+			ss.Levels = append(ss.Levels, sl)
+		}
+		s.Sections = append(s.Sections, ss)
+	}
+	return s
+}
+
+// WriteJSON writes a summary of the report as JSON, counting the outcomes of
+// each level of each section.
+func (r *ImplementationReport) WriteJSON(w io.Writer) error {
+	return writeIndentedJSON(w, r.summary())
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Implementation Report: {{.Subject}} {{.Version}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+.passed { background: #dfd; }
+.failed { background: #fdd; }
+.untested { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Implementation Report: {{.Subject}}</h1>
+<p>Version {{.Version}}, tested {{.Date.Format "2006-01-02 15:04:05 MST"}}.</p>
+<p>{{.Totals.Passed}} passed, {{.Totals.Failed}} failed, {{.Totals.Skipped}} untested.</p>
+{{range .Sections}}
+<h2>Section {{.Section}}</h2>
+{{range .Levels}}
+<h3>{{.Level}} ({{.Passed}} passed, {{.Failed}} failed, {{.Skipped}} untested)</h3>
+<table>
+<tr><th>Test</th><th>Requirement</th><th>Outcome</th><th>Details</th></tr>
+{{range .Results}}<tr class="{{.Outcome}}"><td>{{.Name}}</td><td>{{.Requirement}}</td><td>{{.Outcome}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a static HTML page.
+func (r *ImplementationReport) WriteHTML(w io.Writer) error {
+	return reportHTML.Execute(w, r.summary())
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
@@ -0,0 +1,214 @@
+package report
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-fed/activity/vocab"
+	"github.com/go-fed/httpsig"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	pkcs1PEMType = "RSA PRIVATE KEY"
+	pkcs8PEMType = "PRIVATE KEY"
+	// defaultRSABits is the size of the RSA key generated when none is
+	// given. Many servers reject anything smaller.
+	defaultRSABits = 2048
+)
+
+// GenerateKey creates an RSA private key of the given number of bits; zero
+// selects a sensible default.
+func GenerateKey(bits int) (crypto.PrivateKey, error) {
+	if bits == 0 {
+		bits = defaultRSABits
+	}
+	return rsa.GenerateKey(rand.Reader, bits)
+}
+
+// LoadOrGenerateKey reads a PEM encoded PKCS#1 or PKCS#8 private key from
+// file. If the file does not exist, a new key is generated as by GenerateKey
+// and saved there in PKCS#8 form, so the same key is used on the next run.
+func LoadOrGenerateKey(file string, bits int) (crypto.PrivateKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		return parsePrivateKey(b)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	privKey, err := GenerateKey(bits)
+	if err != nil {
+		return nil, err
+	}
+	b, err = privateKeyPem(privKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(file, b); err != nil {
+		return nil, err
+	}
+	log.Printf("generated new key in %s", file)
+	return privKey, nil
+}
+
+// privateKeyPem encodes privKey as a PKCS#8 PEM block.
+func privateKeyPem(privKey crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pkcs8PEMType, Bytes: der}), nil
+}
+
+func parsePrivateKey(b []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	var privKey crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case pkcs1PEMType:
+		privKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case pkcs8PEMType:
+		privKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyAlgorithm(privKey); err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
+
+// keyAlgorithm determines the HTTP Signatures algorithm used with privKey.
+func keyAlgorithm(privKey crypto.PrivateKey) (httpsig.Algorithm, error) {
+	switch privKey.(type) {
+	case *rsa.PrivateKey:
+		return httpsig.RSA_SHA256, nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", privKey)
+	}
+}
+
+// publicKeyOf returns the public half of privKey.
+func publicKeyOf(privKey crypto.PrivateKey) (crypto.PublicKey, error) {
+	s, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privKey)
+	}
+	return s.Public(), nil
+}
+
+const (
+	// mainKeyFragment identifies the actor's key within the actor
+	// document.
+	mainKeyFragment        = "main-key"
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	// securityContext defines the publicKey vocabulary.
+	securityContext = "https://w3id.org/security/v1"
+)
+
+// keyedPerson is a Person serialized with its publicKey, a property from the
+// security vocabulary that vocab does not know about.
+type keyedPerson struct {
+	*vocab.Person
+	keyId  *url.URL
+	keyPem string
+}
+
+// publicKeyPem encodes pubKey as a PEM block, as found in publicKeyPem.
+func publicKeyPem(pubKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func (k *keyedPerson) Serialize() (map[string]interface{}, error) {
+	m, err := k.Person.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	m["@context"] = []interface{}{activityStreamsContext, securityContext}
+	m["publicKey"] = map[string]interface{}{
+		"id":           k.keyId.String(),
+		"owner":        k.Person.GetId().String(),
+		"publicKeyPem": k.keyPem,
+	}
+	return m, nil
+}
+
+var _ httpsig.Signer = &keyTypeSigner{}
+
+// keyTypeSigner signs with the algorithm matching the kind of the private key
+// it is given, and refuses keys of kinds httpsig cannot sign with. Requests
+// are signed over the headers signatures are verified against, POSTs gaining
+// the Digest and all requests the Host header they need for it.
+type keyTypeSigner struct{}
+
+func (k *keyTypeSigner) signer(pKey crypto.PrivateKey, headers []string) (httpsig.Signer, error) {
+	algo, err := keyAlgorithm(pKey)
+	if err != nil {
+		return nil, err
+	}
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{algo}, headers, httpsig.Signature)
+	return s, err
+}
+
+func (k *keyTypeSigner) SignRequest(pKey crypto.PrivateKey, pubKeyId string, r *http.Request) error {
+	headers := fetchSignedHeaders
+	if r.Body != nil {
+		if err := setDigest(r); err != nil {
+			return err
+		}
+		headers = requiredSignedHeaders
+	}
+	if len(r.Header.Get("Host")) == 0 {
+		r.Header.Set("Host", r.URL.Host)
+	}
+	s, err := k.signer(pKey, headers)
+	if err != nil {
+		return err
+	}
+	return s.SignRequest(pKey, pubKeyId, r)
+}
+
+func (k *keyTypeSigner) SignResponse(pKey crypto.PrivateKey, pubKeyId string, r http.ResponseWriter) error {
+	s, err := k.signer(pKey, nil)
+	if err != nil {
+		return err
+	}
+	return s.SignResponse(pKey, pubKeyId, r)
+}
+
+// setDigest sets the Digest header of r to the SHA-256 of its body, unless it
+// already has one.
+func setDigest(r *http.Request) error {
+	if len(r.Header.Get("Digest")) > 0 {
+		return nil
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(b)
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	return nil
+}
@@ -0,0 +1,245 @@
+package report
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/httpsig"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mockPeerDeliveriesPath = "/deliveries"
+	// maxDeliverySize bounds the body recorded for each delivery.
+	maxDeliverySize = 1 << 20
+)
+
+// MockDelivery is a request received by the inbox of a MockPeer.
+type MockDelivery struct {
+	Received time.Time       `json:"received"`
+	Inbox    string          `json:"inbox"`
+	Headers  http.Header     `json:"headers"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	// RawBody holds the body instead of Body when it is not JSON.
+	RawBody string `json:"rawBody,omitempty"`
+}
+
+// MockPeer is a fake remote server hosting a single actor, so the federation
+// tests that need a peer can run without one on the internet. Its inbox
+// accepts and records anything delivered to it, and the recordings are served
+// as JSON at /deliveries, where a DELETE clears them.
+//
+// It does none of the things a real server does with what it receives.
+type MockPeer struct {
+	scheme     string
+	host       string
+	name       string
+	actorURL   *url.URL
+	keyURL     *url.URL
+	inboxURL   *url.URL
+	privKey    crypto.PrivateKey
+	keyPem     string
+	deliveries []MockDelivery
+	mu         *sync.Mutex
+}
+
+// NewMockPeer creates a peer for the actor /users/{name} on host, with a new
+// RSA key.
+func NewMockPeer(scheme, host, name string) (*MockPeer, error) {
+	if !actorNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid actor name %q", name)
+	}
+	privKey, err := GenerateKey(0)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := publicKeyOf(privKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPem, err := publicKeyPem(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s://%s%s%s", scheme, host, usersPath, name)
+	actorURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	keyURL, err := url.Parse(base + "#" + mainKeyFragment)
+	if err != nil {
+		return nil, err
+	}
+	inboxURL, err := url.Parse(base + inboxPath)
+	if err != nil {
+		return nil, err
+	}
+	return &MockPeer{
+		scheme:   scheme,
+		host:     host,
+		name:     name,
+		actorURL: actorURL,
+		keyURL:   keyURL,
+		inboxURL: inboxURL,
+		privKey:  privKey,
+		keyPem:   keyPem,
+		mu:       &sync.Mutex{},
+	}, nil
+}
+
+// ActorIRI is the id of the peer's actor.
+func (p *MockPeer) ActorIRI() *url.URL {
+	return p.actorURL
+}
+
+// Deliveries returns everything received so far, oldest first.
+func (p *MockPeer) Deliveries() []MockDelivery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := make([]MockDelivery, len(p.deliveries))
+	copy(d, p.deliveries)
+	return d
+}
+
+// Fetch GETs id with client as the peer's actor, signing the request as in an
+// authorized fetch, so tests can see what a server shows that actor.
+func (p *MockPeer) Fetch(client *http.Client, id *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, id.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", activityJSONType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", id.Host)
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, fetchSignedHeaders, httpsig.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SignRequest(p.privKey, p.keyURL.String(), req); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// Reset forgets all deliveries received so far.
+func (p *MockPeer) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deliveries = nil
+}
+
+func (p *MockPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("mock peer received %s request to %q", r.Method, r.URL)
+	base := usersPath + p.name
+	switch r.URL.Path {
+	case base:
+		p.serveActor(w, r)
+	case base + inboxPath:
+		if r.Method == http.MethodPost {
+			p.record(w, r)
+		} else {
+			p.serveEmptyCollection(w, p.inboxURL.String())
+		}
+	case base + outboxPath, base + followingPath, base + followersPath, base + likedPath:
+		p.serveEmptyCollection(w, p.actorURL.String()+strings.TrimPrefix(r.URL.Path, base))
+	case webFingerPath:
+		p.serveWebFinger(w, r)
+	case mockPeerDeliveriesPath:
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, p.Deliveries())
+		case http.MethodDelete:
+			p.Reset()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (p *MockPeer) serveActor(w http.ResponseWriter, r *http.Request) {
+	base := p.actorURL.String()
+	w.Header().Set("Content-Type", ldJSONType)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":          []interface{}{activityStreamsContext, securityContext},
+		"id":                base,
+		"type":              "Person",
+		"name":              "Mock Peer " + p.name,
+		"preferredUsername": p.name,
+		"inbox":             p.inboxURL.String(),
+		"outbox":            base + outboxPath,
+		"following":         base + followingPath,
+		"followers":         base + followersPath,
+		"liked":             base + likedPath,
+		"publicKey": map[string]interface{}{
+			"id":           p.keyURL.String(),
+			"owner":        base,
+			"publicKeyPem": p.keyPem,
+		},
+	})
+}
+
+func (p *MockPeer) serveEmptyCollection(w http.ResponseWriter, id string) {
+	w.Header().Set("Content-Type", ldJSONType)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":     activityStreamsContext,
+		"id":           id,
+		"type":         "OrderedCollection",
+		"totalItems":   0,
+		"orderedItems": []interface{}{},
+	})
+}
+
+func (p *MockPeer) serveWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if resource != fmt.Sprintf("acct:%s@%s", p.name, p.host) && resource != p.actorURL.String() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	writeJSON(w, http.StatusOK, webFingerResponse{
+		Subject: fmt.Sprintf("acct:%s@%s", p.name, p.host),
+		Aliases: []string{p.actorURL.String()},
+		Links: []webFingerLink{
+			{
+				Rel:  "self",
+				Type: activityJSONType,
+				Href: p.actorURL.String(),
+			},
+		},
+	})
+}
+
+func (p *MockPeer) record(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDeliverySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	d := MockDelivery{
+		Received: time.Now(),
+		Inbox:    p.inboxURL.String(),
+		Headers:  r.Header,
+	}
+	if json.Valid(b) {
+		d.Body = json.RawMessage(b)
+	} else {
+		d.RawBody = string(b)
+	}
+	p.mu.Lock()
+	p.deliveries = append(p.deliveries, d)
+	p.mu.Unlock()
+	log.Printf("mock peer recorded delivery: %s", b)
+	// go-fed/activity counts any other status, even 202, as a failed
+	// delivery.
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,558 @@
+package report
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	revokePath            = "/revoke"
+	adminOAuthClientsPath = "/admin/oauth/clients"
+	defaultOAuthTokenTTL  = time.Hour
+	defaultOAuthCodeTTL   = time.Minute
+	// ReadScope authorizes reading an actor's private collections, and
+	// WriteScope posting to its outbox.
+	ReadScope  = "read"
+	WriteScope = "write"
+)
+
+var _ pub.SocialAPIVerifier = &oauthServer{}
+
+// OAuthClient is a client registered with the OAuth 2.0 server.
+type OAuthClient struct {
+	Id   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Secret authenticates confidential clients at the token endpoint.
+	// Public clients have none, and rely on PKCE alone.
+	Secret       string   `json:"secret,omitempty"`
+	RedirectURIs []string `json:"redirectURIs"`
+	// Scopes the client may be granted. Defaults to read and write.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// OAuthConfig configures the OAuth 2.0 server used by WithOAuth.
+type OAuthConfig struct {
+	Clients []OAuthClient
+	// TokenTTL is how long access tokens are valid. Defaults to an hour.
+	TokenTTL time.Duration
+	// CodeTTL is how long authorization codes are valid. Defaults to a
+	// minute.
+	CodeTTL time.Duration
+}
+
+// LoadOAuthClients reads a JSON array of OAuthClients from file.
+func LoadOAuthClients(file string) ([]OAuthClient, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var clients []OAuthClient
+	if err := json.Unmarshal(b, &clients); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", file, err)
+	}
+	return clients, nil
+}
+
+// oauthGrant is what an authorization code or access token stands for.
+type oauthGrant struct {
+	clientId string
+	actor    *url.URL
+	scopes   []string
+	expires  time.Time
+	// Only for authorization codes.
+	redirectURI   string
+	codeChallenge string
+}
+
+func (g *oauthGrant) hasScope(scope string) bool {
+	for _, s := range g.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// oauthServer is an OAuth 2.0 authorization server supporting the
+// authorization code grant with PKCE, as an alternative to
+// doNotUseThisItIsNotOAuth. Actors log in on the consent page with their name
+// and bearer token. Codes and tokens are kept in memory only.
+type oauthServer struct {
+	app      *app
+	clock    pub.Clock
+	tokenTTL time.Duration
+	codeTTL  time.Duration
+	clients  map[string]OAuthClient
+	codes    map[string]*oauthGrant
+	tokens   map[string]*oauthGrant
+	mu       *sync.Mutex
+}
+
+func newOAuthServer(cfg OAuthConfig, clock pub.Clock) (*oauthServer, error) {
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = defaultOAuthTokenTTL
+	}
+	if cfg.CodeTTL <= 0 {
+		cfg.CodeTTL = defaultOAuthCodeTTL
+	}
+	o := &oauthServer{
+		clock:    clock,
+		tokenTTL: cfg.TokenTTL,
+		codeTTL:  cfg.CodeTTL,
+		clients:  make(map[string]OAuthClient),
+		codes:    make(map[string]*oauthGrant),
+		tokens:   make(map[string]*oauthGrant),
+		mu:       &sync.Mutex{},
+	}
+	for _, c := range cfg.Clients {
+		if _, err := o.addClient(c); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (o *oauthServer) addClient(c OAuthClient) (OAuthClient, error) {
+	if len(c.Id) == 0 {
+		return c, fmt.Errorf("client has no id")
+	} else if len(c.RedirectURIs) == 0 {
+		return c, fmt.Errorf("client %q has no redirect URIs", c.Id)
+	}
+	for _, u := range c.RedirectURIs {
+		if ru, err := url.Parse(u); err != nil || !ru.IsAbs() || len(ru.Fragment) > 0 {
+			return c, fmt.Errorf("client %q has invalid redirect URI %q", c.Id, u)
+		}
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{ReadScope, WriteScope}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.clients[c.Id]; ok {
+		return c, fmt.Errorf("client %q already exists", c.Id)
+	}
+	o.clients[c.Id] = c
+	return c, nil
+}
+
+func (o *oauthServer) client(id string) (OAuthClient, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c, ok := o.clients[id]
+	return c, ok
+}
+
+func newOAuthSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oauthError is an error as described by RFC 6749 section 4.1.2.1 and 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// redirectWithError sends the user agent back to the client with err.
+func redirectWithError(w http.ResponseWriter, r *http.Request, redir *url.URL, state string, err oauthError) {
+	q := redir.Query()
+	q.Set("error", err.Code)
+	if len(err.Description) > 0 {
+		q.Set("error_description", err.Description)
+	}
+	if len(state) > 0 {
+		q.Set("state", state)
+	}
+	redir.RawQuery = q.Encode()
+	noStore(w)
+	http.Redirect(w, r, redir.String(), http.StatusFound)
+}
+
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	client        OAuthClient
+	redir         *url.URL
+	state         string
+	scopes        []string
+	codeChallenge string
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization request.
+// Errors about the client or its redirect URI are shown to the user, as the
+// user agent cannot safely be sent back to the client; others are redirected.
+func (o *oauthServer) parseAuthorizeRequest(w http.ResponseWriter, r *http.Request, v url.Values) (*authorizeRequest, bool) {
+	c, ok := o.client(v.Get("client_id"))
+	if !ok {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return nil, false
+	}
+	redirectURI := v.Get("redirect_uri")
+	if len(redirectURI) == 0 && len(c.RedirectURIs) == 1 {
+		redirectURI = c.RedirectURIs[0]
+	}
+	registered := false
+	for _, u := range c.RedirectURIs {
+		registered = registered || u == redirectURI
+	}
+	if !registered {
+		http.Error(w, "redirect_uri is not registered for the client", http.StatusBadRequest)
+		return nil, false
+	}
+	redir, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return nil, false
+	}
+	a := &authorizeRequest{
+		client: c,
+		redir:  redir,
+		state:  v.Get("state"),
+	}
+	if rt := v.Get("response_type"); rt != "code" {
+		code := "unsupported_response_type"
+		if len(rt) == 0 {
+			code = "invalid_request"
+		}
+		redirectWithError(w, r, redir, a.state, oauthError{code, "only the code response type is supported"})
+		return nil, false
+	}
+	a.codeChallenge = v.Get("code_challenge")
+	if len(a.codeChallenge) == 0 || v.Get("code_challenge_method") != "S256" {
+		redirectWithError(w, r, redir, a.state, oauthError{"invalid_request", "a PKCE code_challenge with the S256 method is required"})
+		return nil, false
+	}
+	a.scopes = c.Scopes
+	if scope := v.Get("scope"); len(scope) > 0 {
+		a.scopes = strings.Fields(scope)
+		for _, s := range a.scopes {
+			allowed := false
+			for _, cs := range c.Scopes {
+				allowed = allowed || s == cs
+			}
+			if !allowed {
+				redirectWithError(w, r, redir, a.state, oauthError{"invalid_scope", fmt.Sprintf("scope %q is not allowed for the client", s)})
+				return nil, false
+			}
+		}
+	}
+	return a, true
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.Client}}</title>
+</head>
+<body>
+<h1>Authorize {{.Client}}</h1>
+<p>{{.Client}} asks to act as you with the scopes: {{range .Scopes}}<code>{{.}}</code> {{end}}</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<p><label>Actor name <input name="actor" value="{{.Actor}}"></label></p>
+<p><label>Token <input type="password" name="password"></label></p>
+<p><button name="decision" value="allow">Allow</button> <button name="decision" value="deny">Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+// serveAuthorize is the authorization endpoint. GET shows the consent page,
+// which POSTs back the decision along with the actor's credentials.
+func (o *oauthServer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	var v url.Values
+	switch r.Method {
+	case http.MethodGet:
+		v = r.URL.Query()
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v = r.PostForm
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, ok := o.parseAuthorizeRequest(w, r, v)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet {
+		o.showConsent(w, v, a, "")
+		return
+	} else if v.Get("decision") != "allow" {
+		redirectWithError(w, r, a.redir, a.state, oauthError{"access_denied", "the user denied the request"})
+		return
+	}
+	l, ok := o.app.findActor(func(l *localActor) bool { return l.name == v.Get("actor") })
+	if !ok || subtle.ConstantTimeCompare([]byte(l.token), []byte(v.Get("password"))) != 1 {
+		o.showConsent(w, v, a, "Unknown actor or wrong token.")
+		return
+	}
+	code, err := newOAuthSecret()
+	if err != nil {
+		log.Print(err)
+		redirectWithError(w, r, a.redir, a.state, oauthError{"server_error", ""})
+		return
+	}
+	o.mu.Lock()
+	o.codes[code] = &oauthGrant{
+		clientId:      a.client.Id,
+		actor:         l.actorURL,
+		scopes:        a.scopes,
+		expires:       o.clock.Now().Add(o.codeTTL),
+		redirectURI:   v.Get("redirect_uri"),
+		codeChallenge: a.codeChallenge,
+	}
+	o.mu.Unlock()
+	log.Printf("%s authorized client %q with scopes %v", l.actorURL, a.client.Id, a.scopes)
+	q := a.redir.Query()
+	q.Set("code", code)
+	if len(a.state) > 0 {
+		q.Set("state", a.state)
+	}
+	a.redir.RawQuery = q.Encode()
+	noStore(w)
+	http.Redirect(w, r, a.redir.String(), http.StatusFound)
+}
+
+func (o *oauthServer) showConsent(w http.ResponseWriter, v url.Values, a *authorizeRequest, msg string) {
+	params := make(map[string]string)
+	for _, k := range []string{"response_type", "client_id", "redirect_uri", "state", "scope", "code_challenge", "code_challenge_method"} {
+		if len(v.Get(k)) > 0 {
+			params[k] = v.Get(k)
+		}
+	}
+	name := a.client.Name
+	if len(name) == 0 {
+		name = a.client.Id
+	}
+	noStore(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	status := http.StatusOK
+	if len(msg) > 0 {
+		status = http.StatusUnauthorized
+	}
+	w.WriteHeader(status)
+	if err := consentPage.Execute(w, map[string]interface{}{
+		"Client": name,
+		"Scopes": a.scopes,
+		"Params": params,
+		"Actor":  v.Get("actor"),
+		"Error":  msg,
+	}); err != nil {
+		log.Print(err)
+	}
+}
+
+// authenticateClient checks the credentials of the client making a token or
+// revocation request, given either in the form or with HTTP Basic
+// authentication.
+func (o *oauthServer) authenticateClient(r *http.Request) (OAuthClient, bool) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	c, ok := o.client(id)
+	if !ok {
+		return c, false
+	} else if len(c.Secret) > 0 && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) != 1 {
+		return c, false
+	}
+	return c, true
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, err oauthError) {
+	noStore(w)
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	writeJSON(w, status, err)
+}
+
+// serveToken is the token endpoint, exchanging authorization codes for access
+// tokens.
+func (o *oauthServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", err.Error()})
+		return
+	}
+	c, ok := o.authenticateClient(r)
+	if !ok {
+		writeOAuthError(w, http.StatusUnauthorized, oauthError{"invalid_client", ""})
+		return
+	} else if gt := r.PostForm.Get("grant_type"); gt != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"unsupported_grant_type", "only the authorization_code grant type is supported"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	o.mu.Lock()
+	g, ok := o.codes[code]
+	// Codes are used once, even when the exchange fails.
+	delete(o.codes, code)
+	o.mu.Unlock()
+	if !ok || g.clientId != c.Id || o.clock.Now().After(g.expires) {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "unknown or expired code"})
+		return
+	} else if r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "redirect_uri does not match"})
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(verifier[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(g.codeChallenge)) != 1 {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "code_verifier does not match"})
+		return
+	}
+	token, err := newOAuthSecret()
+	if err != nil {
+		log.Print(err)
+		writeOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+	o.mu.Lock()
+	o.tokens[token] = &oauthGrant{
+		clientId: c.Id,
+		actor:    g.actor,
+		scopes:   g.scopes,
+		expires:  o.clock.Now().Add(o.tokenTTL),
+	}
+	o.mu.Unlock()
+	noStore(w)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(o.tokenTTL / time.Second),
+		"scope":        strings.Join(g.scopes, " "),
+	})
+}
+
+// serveRevoke revokes access tokens as described by RFC 7009. Unknown tokens
+// are not an error.
+func (o *oauthServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", err.Error()})
+		return
+	}
+	c, ok := o.authenticateClient(r)
+	if !ok {
+		writeOAuthError(w, http.StatusUnauthorized, oauthError{"invalid_client", ""})
+		return
+	}
+	token := r.PostForm.Get("token")
+	o.mu.Lock()
+	if g, ok := o.tokens[token]; ok && g.clientId == c.Id {
+		delete(o.tokens, token)
+		log.Printf("client %q revoked a token of %s", c.Id, g.actor)
+	}
+	o.mu.Unlock()
+	noStore(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// grant returns the unexpired grant of the bearer token of r.
+func (o *oauthServer) grant(r *http.Request) (*oauthGrant, error) {
+	bearer := r.Header.Get("Authorization")
+	if !strings.HasPrefix(bearer, "Bearer ") {
+		return nil, fmt.Errorf("no bearer token")
+	}
+	token := strings.TrimPrefix(bearer, "Bearer ")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	g, ok := o.tokens[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown bearer token")
+	} else if o.clock.Now().After(g.expires) {
+		delete(o.tokens, token)
+		return nil, fmt.Errorf("expired bearer token")
+	}
+	return g, nil
+}
+
+// Verify implements SocialAPIVerifier, which reads with it, authorizing tokens
+// with the read scope.
+func (o *oauthServer) Verify(r *http.Request) (authenticatedUser *url.URL, authn, authz bool, err error) {
+	g, err := o.grant(r)
+	if err != nil {
+		return nil, false, false, err
+	}
+	return g.actor, true, g.hasScope(ReadScope), nil
+}
+
+// VerifyForOutbox implements SocialAPIVerifier, authorizing tokens with the
+// write scope of the outbox's actor.
+func (o *oauthServer) VerifyForOutbox(r *http.Request, outbox *url.URL) (authn, authz bool, err error) {
+	g, err := o.grant(r)
+	if err != nil {
+		return false, false, err
+	}
+	l, ok := o.app.actorForBox(outbox)
+	if !ok || *outbox != *l.outboxURL {
+		return true, false, fmt.Errorf("bad outbox url %q", outbox)
+	}
+	return true, *g.actor == *l.actorURL && g.hasScope(WriteScope), nil
+}
+
+// serveAdminClients lists the registered clients on GET, without their
+// secrets, and registers a JSON encoded OAuthClient on POST.
+func (o *oauthServer) serveAdminClients(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		o.mu.Lock()
+		clients := []OAuthClient{}
+		for _, c := range o.clients {
+			c.Secret = ""
+			clients = append(clients, c)
+		}
+		o.mu.Unlock()
+		sort.Slice(clients, func(i, j int) bool { return clients[i].Id < clients[j].Id })
+		writeJSON(w, http.StatusOK, clients)
+	case http.MethodPost:
+		var c OAuthClient
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, err := o.addClient(c)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot register client: %s", err), http.StatusBadRequest)
+			return
+		}
+		log.Printf("registered client %q", c.Id)
+		c.Secret = ""
+		writeJSON(w, http.StatusCreated, c)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
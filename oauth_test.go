@@ -0,0 +1,258 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRedirectURI  = "https://client.example/callback"
+	testCodeVerifier = "a-code-verifier-long-enough-for-the-test"
+)
+
+// oauthTest is an OAuth server for the actor alice of an accessTest, with a
+// confidential client "suite" that may read and write, and a public client
+// "writer" that may only write.
+type oauthTest struct {
+	*accessTest
+	o     *oauthServer
+	clock *TestClock
+}
+
+func newOAuthTest(t *testing.T) *oauthTest {
+	ot := &oauthTest{accessTest: newAccessTest(t), clock: frozenClock()}
+	var err error
+	ot.o, err = newOAuthServer(OAuthConfig{Clients: []OAuthClient{
+		{Id: "suite", Name: "Test Suite", Secret: "s3cret", RedirectURIs: []string{testRedirectURI}},
+		{Id: "writer", RedirectURIs: []string{testRedirectURI}, Scopes: []string{WriteScope}},
+	}}, ot.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ot.o.app = ot.app
+	ot.app.verifier = ot.o
+	return ot
+}
+
+func codeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// authorize has alice allow client on the consent page, returning the code.
+func (ot *oauthTest) authorize(t *testing.T, client string) string {
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+		"actor":                 {"alice"},
+		"password":              {ot.alice.token},
+		"decision":              {"allow"},
+	}
+	req := httptest.NewRequest(http.MethodPost, authPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ot.o.serveAuthorize(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("authorizing: got %d: %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := loc.Query(); q.Get("state") != "xyz" || len(q.Get("code")) == 0 {
+		t.Fatalf("redirected to %s", loc)
+	}
+	return loc.Query().Get("code")
+}
+
+// exchange posts form to the token endpoint, filling in what is missing for a
+// valid exchange of code by the suite client.
+func (ot *oauthTest) exchange(code string, form url.Values) *httptest.ResponseRecorder {
+	for k, v := range map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"redirect_uri":  testRedirectURI,
+		"client_id":     "suite",
+		"client_secret": "s3cret",
+		"code_verifier": testCodeVerifier,
+	} {
+		if _, ok := form[k]; !ok {
+			form.Set(k, v)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, tokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ot.o.serveToken(rec, req)
+	return rec
+}
+
+// token returns an access token for client, failing the test if there is
+// none.
+func (ot *oauthTest) token(t *testing.T, client string) string {
+	form := url.Values{}
+	if client != "suite" {
+		form.Set("client_id", client)
+		form.Set("client_secret", "")
+	}
+	rec := ot.exchange(ot.authorize(t, client), form)
+	var m map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(stringValue(m["access_token"])) == 0 {
+		t.Fatalf("exchanging the code: got %d: %v", rec.Code, m)
+	}
+	return stringValue(m["access_token"])
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/users/alice/inbox", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestOAuthFlow(t *testing.T) {
+	ot := newOAuthTest(t)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"suite"},
+		"redirect_uri":          {testRedirectURI},
+		"code_challenge":        {codeChallenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	rec := httptest.NewRecorder()
+	ot.o.serveAuthorize(rec, httptest.NewRequest(http.MethodGet, authPath+"?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Test Suite") {
+		t.Fatalf("consent page: got %d: %s", rec.Code, rec.Body)
+	}
+
+	token := ot.token(t, "suite")
+	user, authn, authz, err := ot.o.Verify(bearerRequest(token))
+	if err != nil || !authn || !authz || user.String() != ot.alice.actorURL.String() {
+		t.Errorf("Verify: got %v, %v, %v, %v", user, authn, authz, err)
+	}
+	if authn, authz, err := ot.o.VerifyForOutbox(bearerRequest(token), ot.alice.outboxURL); err != nil || !authn || !authz {
+		t.Errorf("VerifyForOutbox: got %v, %v, %v", authn, authz, err)
+	}
+}
+
+func TestOAuthTokenRefused(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// exchange exchanges a code of the suite client.
+		exchange func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder
+		status   int
+		error    string
+	}{
+		{"PKCE mismatch", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			return ot.exchange(code, url.Values{"code_verifier": {"another-verifier"}})
+		}, http.StatusBadRequest, "invalid_grant"},
+		{"expired code", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			ot.clock.Advance(defaultOAuthCodeTTL + time.Second)
+			return ot.exchange(code, url.Values{})
+		}, http.StatusBadRequest, "invalid_grant"},
+		{"reused code", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			if rec := ot.exchange(code, url.Values{}); rec.Code != http.StatusOK {
+				t.Fatalf("first exchange: got %d: %s", rec.Code, rec.Body)
+			}
+			return ot.exchange(code, url.Values{})
+		}, http.StatusBadRequest, "invalid_grant"},
+		{"wrong client", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			return ot.exchange(code, url.Values{"client_id": {"writer"}, "client_secret": {""}})
+		}, http.StatusBadRequest, "invalid_grant"},
+		{"wrong client secret", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			return ot.exchange(code, url.Values{"client_secret": {"guess"}})
+		}, http.StatusUnauthorized, "invalid_client"},
+		{"redirect_uri mismatch", func(t *testing.T, ot *oauthTest, code string) *httptest.ResponseRecorder {
+			return ot.exchange(code, url.Values{"redirect_uri": {"https://client.example/elsewhere"}})
+		}, http.StatusBadRequest, "invalid_grant"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ot := newOAuthTest(t)
+			rec := test.exchange(t, ot, ot.authorize(t, "suite"))
+			var e oauthError
+			json.Unmarshal(rec.Body.Bytes(), &e)
+			if rec.Code != test.status || e.Code != test.error {
+				t.Errorf("got %d %q, want %d %q", rec.Code, e.Code, test.status, test.error)
+			}
+		})
+	}
+}
+
+func TestOAuthAuthorizeRedirectURIMismatch(t *testing.T) {
+	ot := newOAuthTest(t)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"suite"},
+		"redirect_uri":          {"https://attacker.example/callback"},
+		"code_challenge":        {codeChallenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	rec := httptest.NewRecorder()
+	ot.o.serveAuthorize(rec, httptest.NewRequest(http.MethodGet, authPath+"?"+q.Encode(), nil))
+	// The user agent is not sent to an unregistered redirect URI.
+	if rec.Code != http.StatusBadRequest || len(rec.Header().Get("Location")) > 0 {
+		t.Errorf("got %d to %q, want %d", rec.Code, rec.Header().Get("Location"), http.StatusBadRequest)
+	}
+}
+
+func TestOAuthRevokedToken(t *testing.T) {
+	ot := newOAuthTest(t)
+	token := ot.token(t, "suite")
+	form := url.Values{"token": {token}, "client_id": {"suite"}, "client_secret": {"s3cret"}}
+	req := httptest.NewRequest(http.MethodPost, revokePath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ot.o.serveRevoke(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revoking: got %d: %s", rec.Code, rec.Body)
+	}
+	if _, authn, _, err := ot.o.Verify(bearerRequest(token)); err == nil || authn {
+		t.Error("revoked token still verifies")
+	}
+}
+
+func TestOAuthExpiredToken(t *testing.T) {
+	ot := newOAuthTest(t)
+	token := ot.token(t, "suite")
+	ot.clock.Advance(defaultOAuthTokenTTL + time.Second)
+	if _, authn, _, err := ot.o.Verify(bearerRequest(token)); err == nil || authn {
+		t.Error("expired token still verifies")
+	}
+}
+
+func TestOAuthReadScopeRequiredToRead(t *testing.T) {
+	ot := newOAuthTest(t)
+	id := ot.store(t, map[string]interface{}{"to": ot.bob.ActorIRI().String()})
+	for _, test := range []struct {
+		client string
+		want   int
+	}{
+		{"suite", http.StatusOK},
+		{"writer", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, id.String(), nil)
+		req.Header.Set("Accept", activityJSONType)
+		req.Header.Set("Authorization", "Bearer "+ot.token(t, test.client))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("token of %s: got %d, want %d", test.client, resp.StatusCode, test.want)
+		}
+	}
+}
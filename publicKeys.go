@@ -0,0 +1,266 @@
+package report
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/httpsig"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPublicKeyTTL is how long a fetched key is trusted before it is
+	// fetched again.
+	defaultPublicKeyTTL = time.Hour
+	// maxActorDocumentSize bounds what is read when dereferencing a key.
+	maxActorDocumentSize = 1 << 20
+	activityJSONType     = "application/activity+json"
+	ldJSONType           = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+)
+
+// remoteKey is a public key fetched from its owner's actor document.
+type remoteKey struct {
+	pubKey  crypto.PublicKey
+	algo    httpsig.Algorithm
+	owner   *url.URL
+	fetched time.Time
+}
+
+// publicKeyCache dereferences the public keys of remote actors and keeps them
+// for a while, since every incoming signed request needs one.
+type publicKeyCache struct {
+	client pub.HttpClient
+	clock  pub.Clock
+	ttl    time.Duration
+	// sign signs outgoing fetches, for peers that only serve actors to
+	// authenticated servers. May be nil.
+	sign  func(r *http.Request) error
+	keys  map[string]*remoteKey
+	mu    *sync.Mutex
+	fetch *sync.Mutex
+}
+
+func newPublicKeyCache(client pub.HttpClient, clock pub.Clock, ttl time.Duration, sign func(r *http.Request) error) *publicKeyCache {
+	if ttl <= 0 {
+		ttl = defaultPublicKeyTTL
+	}
+	return &publicKeyCache{
+		client: client,
+		clock:  clock,
+		ttl:    ttl,
+		sign:   sign,
+		keys:   make(map[string]*remoteKey),
+		mu:     &sync.Mutex{},
+		fetch:  &sync.Mutex{},
+	}
+}
+
+// Get returns the key with the given id, fetching it if it is not cached or
+// has expired.
+func (p *publicKeyCache) Get(c context.Context, keyId string) (*remoteKey, error) {
+	p.mu.Lock()
+	k, ok := p.keys[keyId]
+	p.mu.Unlock()
+	if ok && p.clock.Now().Sub(k.fetched) < p.ttl {
+		return k, nil
+	}
+	return p.refetch(c, keyId)
+}
+
+// Verify calls verify with the key with the given id. If that fails with a
+// cached key, the owner may have rotated it, so the key is fetched again and
+// verify is retried once.
+func (p *publicKeyCache) Verify(c context.Context, keyId string, verify func(k *remoteKey) error) (*remoteKey, error) {
+	p.mu.Lock()
+	_, cached := p.keys[keyId]
+	p.mu.Unlock()
+	k, err := p.Get(c, keyId)
+	if err != nil {
+		return nil, err
+	}
+	if err = verify(k); err == nil || !cached {
+		return k, err
+	}
+	log.Printf("verifying with cached key %s failed, fetching it again: %s", keyId, err)
+	if k, err = p.refetch(c, keyId); err != nil {
+		return nil, err
+	}
+	return k, verify(k)
+}
+
+func (p *publicKeyCache) refetch(c context.Context, keyId string) (*remoteKey, error) {
+	// Only one fetch at a time, so a burst of deliveries from a new peer
+	// does not dereference its key many times over.
+	p.fetch.Lock()
+	defer p.fetch.Unlock()
+	p.mu.Lock()
+	k, ok := p.keys[keyId]
+	p.mu.Unlock()
+	if ok && p.clock.Now().Sub(k.fetched) < time.Second {
+		return k, nil
+	}
+	k, err := p.dereference(c, keyId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys[keyId] = k
+	p.mu.Unlock()
+	return k, nil
+}
+
+// dereference fetches the document at keyId and finds the key in it. The
+// document is either the owner's actor, embedding the key as publicKey, or
+// something else, such as the key itself, in which case the owner is fetched
+// to confirm it claims the key, and its copy of the key is used.
+func (p *publicKeyCache) dereference(c context.Context, keyId string) (*remoteKey, error) {
+	u, err := url.Parse(keyId)
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.fetchJSON(c, u)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := findPublicKey(m, keyId)
+	if !ok {
+		return nil, fmt.Errorf("no public key %s in fetched document", keyId)
+	}
+	owner, err := url.Parse(stringValue(key["owner"]))
+	if err != nil {
+		return nil, fmt.Errorf("bad owner of public key %s: %s", keyId, err)
+	} else if len(owner.String()) == 0 {
+		return nil, fmt.Errorf("public key %s has no owner", keyId)
+	}
+	// Only the document at the fetched URL speaks for itself: anyone can
+	// serve a key claiming any owner, so unless the fetched document is the
+	// owner's actor, the owner is fetched from its own origin and must list
+	// the key.
+	fetched := *u
+	fetched.Fragment = ""
+	if id := stringValue(m["id"]); id != fetched.String() || id != owner.String() {
+		om, err := p.fetchJSON(c, owner)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok = findPublicKey(om, keyId); !ok || stringValue(om["id"]) != owner.String() {
+			return nil, fmt.Errorf("owner %s does not claim public key %s", owner, keyId)
+		}
+	}
+	pubKey, err := parsePublicKeyPem(stringValue(key["publicKeyPem"]))
+	if err != nil {
+		return nil, fmt.Errorf("public key %s: %s", keyId, err)
+	}
+	algo, err := publicKeyAlgorithm(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key %s: %s", keyId, err)
+	}
+	log.Printf("fetched public key %s of %s", keyId, owner)
+	return &remoteKey{
+		pubKey:  pubKey,
+		algo:    algo,
+		owner:   owner,
+		fetched: p.clock.Now(),
+	}, nil
+}
+
+func (p *publicKeyCache) fetchJSON(c context.Context, u *url.URL) (map[string]interface{}, error) {
+	noFragment := *u
+	noFragment.Fragment = ""
+	req, err := http.NewRequest(http.MethodGet, noFragment.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c)
+	req.Header.Set("Accept", activityJSONType+", "+ldJSONType)
+	req.Header.Set("Date", p.clock.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+	if p.sign != nil {
+		if err := p.sign(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxActorDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", u, err)
+	}
+	return m, nil
+}
+
+// findPublicKey looks for the key with the given id in a document that is
+// either the key itself or an actor with a publicKey property.
+func findPublicKey(m map[string]interface{}, keyId string) (map[string]interface{}, bool) {
+	if stringValue(m["id"]) == keyId {
+		if _, ok := m["publicKeyPem"]; ok {
+			return m, true
+		}
+	}
+	var keys []interface{}
+	switch v := m["publicKey"].(type) {
+	case map[string]interface{}:
+		keys = []interface{}{v}
+	case []interface{}:
+		keys = v
+	}
+	for _, k := range keys {
+		if km, ok := k.(map[string]interface{}); ok && stringValue(km["id"]) == keyId {
+			return km, true
+		}
+	}
+	return nil, false
+}
+
+// stringValue returns v if it is a string, and the empty string otherwise.
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func parsePublicKeyPem(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// publicKeyAlgorithm determines the HTTP Signatures algorithm used with
+// pubKey.
+func publicKeyAlgorithm(pubKey crypto.PublicKey) (httpsig.Algorithm, error) {
+	switch pubKey.(type) {
+	case *rsa.PublicKey:
+		return httpsig.RSA_SHA256, nil
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pubKey)
+	}
+}
@@ -0,0 +1,264 @@
+package report
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// docServer is a remote server serving JSON documents by path, which may be
+// changed between requests, and counting the requests for each.
+type docServer struct {
+	*httptest.Server
+	docs    map[string]map[string]interface{}
+	fetches map[string]int
+	mu      *sync.Mutex
+}
+
+func newDocServer(t *testing.T) *docServer {
+	d := &docServer{
+		docs:    make(map[string]map[string]interface{}),
+		fetches: make(map[string]int),
+		mu:      &sync.Mutex{},
+	}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.fetches[r.URL.Path]++
+		doc, ok := d.docs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", activityJSONType)
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(d.Close)
+	return d
+}
+
+func (d *docServer) set(path string, doc map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.docs[path] = doc
+}
+
+func (d *docServer) count(path string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.fetches[path]
+}
+
+// testKey generates an RSA key pair, returning the public key and its PEM.
+func testKey(t *testing.T) (crypto.PublicKey, string) {
+	privKey, err := GenerateKey(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := publicKeyOf(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := publicKeyPem(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey, pem
+}
+
+func keyDoc(id, owner, pem string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           id,
+		"owner":        owner,
+		"publicKeyPem": pem,
+	}
+}
+
+func actorDoc(id string, keys ...map[string]interface{}) map[string]interface{} {
+	m := map[string]interface{}{
+		"id":    id,
+		"type":  "Person",
+		"inbox": id + "/inbox",
+	}
+	if len(keys) > 0 {
+		var ks []interface{}
+		for _, k := range keys {
+			ks = append(ks, k)
+		}
+		m["publicKey"] = ks
+	}
+	return m
+}
+
+func sameKey(a, b crypto.PublicKey) bool {
+	e, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && e.Equal(b)
+}
+
+func newTestKeyCache(clock *TestClock) *publicKeyCache {
+	return newPublicKeyCache(&http.Client{}, clock, time.Hour, nil)
+}
+
+func frozenClock() *TestClock {
+	clock := NewTestClock()
+	clock.Freeze(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	return clock
+}
+
+func TestPublicKeyEmbeddedInActor(t *testing.T) {
+	peer := newDocServer(t)
+	pubKey, pem := testKey(t)
+	actor := peer.URL + "/actor"
+	keyId := actor + "#main-key"
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, actor, pem)))
+
+	k, err := newTestKeyCache(frozenClock()).Get(context.Background(), keyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.owner.String() != actor {
+		t.Errorf("owner is %s, want %s", k.owner, actor)
+	}
+	if !sameKey(k.pubKey, pubKey) {
+		t.Error("got a different key")
+	}
+	if n := peer.count("/actor"); n != 1 {
+		t.Errorf("actor fetched %d times, want 1", n)
+	}
+}
+
+func TestPublicKeyStandaloneDocument(t *testing.T) {
+	peer := newDocServer(t)
+	pubKey, pem := testKey(t)
+	actor := peer.URL + "/actor"
+	keyId := peer.URL + "/key"
+	peer.set("/key", keyDoc(keyId, actor, pem))
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, actor, pem)))
+
+	k, err := newTestKeyCache(frozenClock()).Get(context.Background(), keyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.owner.String() != actor || !sameKey(k.pubKey, pubKey) {
+		t.Errorf("got key of %s, want the key of %s", k.owner, actor)
+	}
+	if n := peer.count("/actor"); n != 1 {
+		t.Errorf("owner fetched %d times, want 1", n)
+	}
+
+	// The owner must claim the key.
+	otherKeyId := peer.URL + "/other-key"
+	peer.set("/other-key", keyDoc(otherKeyId, actor, pem))
+	if _, err := newTestKeyCache(frozenClock()).Get(context.Background(), otherKeyId); err == nil {
+		t.Error("accepted a key its owner does not claim")
+	}
+}
+
+func TestPublicKeyOwnerMismatch(t *testing.T) {
+	peer := newDocServer(t)
+	_, pem := testKey(t)
+	actor := peer.URL + "/actor"
+	other := peer.URL + "/other"
+	keyId := actor + "#main-key"
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, other, pem)))
+	peer.set("/other", actorDoc(other))
+
+	if _, err := newTestKeyCache(frozenClock()).Get(context.Background(), keyId); err == nil {
+		t.Error("accepted a key embedded in an actor that does not own it")
+	}
+}
+
+func TestPublicKeySpoofedOwner(t *testing.T) {
+	victim := newDocServer(t)
+	attacker := newDocServer(t)
+	_, victimPem := testKey(t)
+	_, attackerPem := testKey(t)
+	victimActor := victim.URL + "/actor"
+	victim.set("/actor", actorDoc(victimActor, keyDoc(victimActor+"#main-key", victimActor, victimPem)))
+
+	// The attacker serves a document claiming to be the victim, embedding
+	// its own key.
+	keyId := attacker.URL + "/k"
+	attacker.set("/k", actorDoc(victimActor, keyDoc(keyId, victimActor, attackerPem)))
+
+	if k, err := newTestKeyCache(frozenClock()).Get(context.Background(), keyId); err == nil {
+		t.Errorf("accepted the attacker's key as owned by %s", k.owner)
+	}
+	if n := victim.count("/actor"); n != 1 {
+		t.Errorf("victim fetched %d times, want 1", n)
+	}
+}
+
+func TestPublicKeyExpires(t *testing.T) {
+	peer := newDocServer(t)
+	_, pem := testKey(t)
+	actor := peer.URL + "/actor"
+	keyId := actor + "#main-key"
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, actor, pem)))
+	clock := frozenClock()
+	keys := newTestKeyCache(clock)
+
+	for _, step := range []struct {
+		advance time.Duration
+		fetches int
+	}{
+		{0, 1},
+		{30 * time.Minute, 1},
+		{31 * time.Minute, 2},
+		{time.Minute, 2},
+	} {
+		clock.Advance(step.advance)
+		if _, err := keys.Get(context.Background(), keyId); err != nil {
+			t.Fatal(err)
+		}
+		if n := peer.count("/actor"); n != step.fetches {
+			t.Errorf("after %s, actor fetched %d times, want %d", step.advance, n, step.fetches)
+		}
+	}
+}
+
+func TestPublicKeyRefetchedAfterFailedVerify(t *testing.T) {
+	peer := newDocServer(t)
+	_, oldPem := testKey(t)
+	newKey, newPem := testKey(t)
+	actor := peer.URL + "/actor"
+	keyId := actor + "#main-key"
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, actor, oldPem)))
+	clock := frozenClock()
+	keys := newTestKeyCache(clock)
+	if _, err := keys.Get(context.Background(), keyId); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner rotates its key, so the cached one no longer verifies.
+	peer.set("/actor", actorDoc(actor, keyDoc(keyId, actor, newPem)))
+	clock.Advance(time.Minute)
+	verify := func(k *remoteKey) error {
+		if !sameKey(k.pubKey, newKey) {
+			return errBadSignature
+		}
+		return nil
+	}
+	if _, err := keys.Verify(context.Background(), keyId, verify); err != nil {
+		t.Fatalf("verifying after rotation: %s", err)
+	}
+	if n := peer.count("/actor"); n != 2 {
+		t.Errorf("actor fetched %d times, want 2", n)
+	}
+
+	// A key just fetched is not fetched again when it fails.
+	if _, err := keys.Verify(context.Background(), keyId, func(*remoteKey) error { return errBadSignature }); err != errBadSignature {
+		t.Errorf("got %v, want the verify error", err)
+	}
+	if n := peer.count("/actor"); n != 2 {
+		t.Errorf("actor fetched %d times, want 2", n)
+	}
+}
+
+var errBadSignature = errors.New("bad signature")
@@ -0,0 +1,202 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// redactedFormFields are the secrets redacted from recorded form bodies: the
+// password of the OAuth consent form, the client_secret and code_verifier
+// sent to /token, and the token sent to /revoke.
+var redactedFormFields = []string{"password", "client_secret", "code_verifier", "token"}
+
+// redactedResponseFields are the secrets redacted from recorded JSON
+// responses, such as those of /token.
+var redactedResponseFields = []string{"access_token", "refresh_token"}
+
+type recordedLockKeyType string
+
+// recordedLockKeyName holds a *int in the context of a recorded request,
+// which SetReportMux sets to the lock key it uses for the request.
+const recordedLockKeyName = recordedLockKeyType("recordedLockKey")
+
+// TrafficRecord is one request and response exchanged with the server, as
+// written by a Recorder.
+type TrafficRecord struct {
+	Started         time.Time     `json:"started"`
+	Duration        time.Duration `json:"duration"`
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	RequestHeaders  http.Header   `json:"requestHeaders"`
+	RequestBody     string        `json:"requestBody,omitempty"`
+	Status          int           `json:"status"`
+	ResponseHeaders http.Header   `json:"responseHeaders"`
+	ResponseBody    string        `json:"responseBody,omitempty"`
+	// LockKey is the key the request locked objects in the Store with, or 0
+	// if it did not get one.
+	LockKey int `json:"lockKey,omitempty"`
+}
+
+// Recorder is an http.Handler writing a TrafficRecord for every exchange with
+// the handler it wraps, as one line of JSON each, so a test run leaves an
+// exact transcript behind. Authorization headers, and the secrets in form
+// bodies and token responses, are redacted.
+type Recorder struct {
+	h  http.Handler
+	e  *json.Encoder
+	mu *sync.Mutex
+}
+
+// NewRecorder wraps h, writing its traffic to w.
+func NewRecorder(h http.Handler, w io.Writer) *Recorder {
+	return &Recorder{
+		h:  h,
+		e:  json.NewEncoder(w),
+		mu: &sync.Mutex{},
+	}
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := TrafficRecord{
+		Started:        time.Now(),
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: redactHeaders(r.Header),
+	}
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("cannot record request body: %s", err)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		t.RequestBody = redactForm(r.Header.Get("Content-Type"), string(b))
+	}
+	lockKey := new(int)
+	r = r.WithContext(context.WithValue(r.Context(), recordedLockKeyName, lockKey))
+	rw := &recordingResponseWriter{ResponseWriter: w}
+	rec.h.ServeHTTP(rw, r)
+	t.Duration = time.Since(t.Started)
+	t.Status = rw.status
+	if t.Status == 0 {
+		t.Status = http.StatusOK
+	}
+	t.ResponseHeaders = rw.header
+	if t.ResponseHeaders == nil {
+		t.ResponseHeaders = cloneHeader(w.Header())
+	}
+	t.ResponseBody = redactResponse(t.ResponseHeaders.Get("Content-Type"), rw.body.String())
+	t.LockKey = *lockKey
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.e.Encode(t); err != nil {
+		log.Printf("cannot record exchange with %q: %s", t.URL, err)
+	}
+}
+
+// recordLockKey tells the Recorder of r, if any, the lock key used for r.
+func recordLockKey(r *http.Request, key int) {
+	if p, ok := r.Context().Value(recordedLockKeyName).(*int); ok {
+		*p = key
+	}
+}
+
+// recordingResponseWriter keeps a copy of the response it writes.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = cloneHeader(w.ResponseWriter.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func redactHeaders(h http.Header) http.Header {
+	c := cloneHeader(h)
+	if _, ok := c["Authorization"]; ok {
+		c.Set("Authorization", redacted)
+	}
+	return c
+}
+
+// redactForm redacts the redactedFormFields of a form encoded body, or all of
+// it if it cannot be parsed.
+func redactForm(contentType, body string) string {
+	if !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return body
+	}
+	v, err := url.ParseQuery(body)
+	if err != nil {
+		return redacted
+	}
+	changed := false
+	for _, f := range redactedFormFields {
+		if _, ok := v[f]; ok {
+			v.Set(f, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	return v.Encode()
+}
+
+// redactResponse redacts the redactedResponseFields of a JSON object body.
+func redactResponse(contentType, body string) string {
+	if !strings.Contains(contentType, "json") {
+		return body
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return body
+	}
+	changed := false
+	for _, f := range redactedResponseFields {
+		if _, ok := m[f]; ok {
+			m[f] = redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return redacted
+	}
+	return string(b)
+}
@@ -0,0 +1,99 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRecorderRedactsSecrets(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		contentType string
+		body        string
+		respType    string
+		resp        string
+		// secrets must not appear anywhere in the record.
+		secrets []string
+		// kept must still appear in it.
+		kept []string
+	}{
+		{
+			name:        "oauth token exchange",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "grant_type=authorization_code&code=abc&client_id=suite&client_secret=s3cret&code_verifier=v3rifier",
+			respType:    "application/json;charset=UTF-8",
+			resp:        `{"access_token": "t0ken", "token_type": "Bearer"}`,
+			secrets:     []string{"s3cret", "v3rifier", "t0ken"},
+			kept:        []string{"authorization_code", "suite", "Bearer"},
+		},
+		{
+			name:        "stub token exchange",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "grant_type=authorization_code&code=" + stubAuthorizationCode,
+			respType:    "application/json;charset=UTF-8",
+			resp:        `{"access_token": "` + defaultActorToken + `X", "token_type": "Bearer"}`,
+			secrets:     []string{defaultActorToken + "X"},
+			kept:        []string{"authorization_code"},
+		},
+		{
+			name:        "consent",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "actor=report&password=p4ss&decision=allow",
+			respType:    "text/html",
+			resp:        "<p>ok</p>",
+			secrets:     []string{"p4ss"},
+			kept:        []string{"report", "allow", "ok"},
+		},
+		{
+			name:        "unparsable form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "password=p4ss%zz",
+			respType:    "text/plain",
+			secrets:     []string{"p4ss"},
+		},
+		{
+			name:        "activity",
+			contentType: activityJSONType,
+			body:        `{"type": "Note", "content": "password=hunter2"}`,
+			respType:    activityJSONType,
+			resp:        `{"token": "kept"}`,
+			kept:        []string{"hunter2", "kept"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			rec := NewRecorder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The handler sees the secrets.
+				if r.FormValue("password") == redacted {
+					t.Error("handler got the redacted password")
+				}
+				w.Header().Set("Content-Type", test.respType)
+				w.Write([]byte(test.resp))
+			}), &out)
+			req := httptest.NewRequest(http.MethodPost, tokenPath, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			rec.ServeHTTP(httptest.NewRecorder(), req)
+
+			var tr TrafficRecord
+			if err := json.Unmarshal(out.Bytes(), &tr); err != nil {
+				t.Fatal(err)
+			}
+			record := out.String()
+			for _, s := range test.secrets {
+				if strings.Contains(record, s) || strings.Contains(record, url.QueryEscape(s)) {
+					t.Errorf("record contains %q: %s", s, record)
+				}
+			}
+			for _, s := range test.kept {
+				if !strings.Contains(tr.RequestBody+tr.ResponseBody, s) {
+					t.Errorf("record lost %q: %s", s, record)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// volatileProperties differ from run to run without the behavior changing,
+// so they are not compared by ReplayTraffic.
+var volatileProperties = map[string]bool{
+	"published":    true,
+	"updated":      true,
+	"publicKeyPem": true,
+	// Redacted by the Recorder.
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// comparedHeaders are the response headers compared by ReplayTraffic. Others,
+// such as Date, are not.
+var comparedHeaders = []string{"Content-Type", "Location"}
+
+// ReplayResult is the response to a recorded request when replayed.
+type ReplayResult struct {
+	Record TrafficRecord
+	Status int
+	Body   string
+	// Differences describes how the response differs from the recorded
+	// one, and is empty if it does not.
+	Differences []string
+}
+
+// UnsentRequest is a request made with a RecordingClient.
+type UnsentRequest struct {
+	Method string
+	URL    string
+}
+
+// RecordingClient is a pub.HttpClient recording the requests made with it
+// instead of sending them, so that a replayed session does not deliver
+// activities or fetch anything. POSTs are answered with 200 OK, which
+// go-fed/activity takes for a delivery, and everything else with 404 Not
+// Found.
+type RecordingClient struct {
+	requests []UnsentRequest
+	mu       *sync.Mutex
+}
+
+// NewRecordingClient creates a RecordingClient with no requests made.
+func NewRecordingClient() *RecordingClient {
+	return &RecordingClient{mu: &sync.Mutex{}}
+}
+
+func (c *RecordingClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, UnsentRequest{Method: req.Method, URL: req.URL.String()})
+	c.mu.Unlock()
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	status := http.StatusNotFound
+	if req.Method == http.MethodPost {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// Requests returns the requests made so far.
+func (c *RecordingClient) Requests() []UnsentRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]UnsentRequest(nil), c.requests...)
+}
+
+// ReadTrafficRecords reads the records written by a Recorder.
+func ReadTrafficRecords(r io.Reader) ([]TrafficRecord, error) {
+	var records []TrafficRecord
+	d := json.NewDecoder(r)
+	for {
+		var t TrafficRecord
+		if err := d.Decode(&t); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, fmt.Errorf("reading record %d: %s", len(records)+1, err)
+		}
+		records = append(records, t)
+	}
+}
+
+// ReplayTraffic makes each recorded request to h in order, and compares the
+// responses with the recorded ones. Ids of new objects under newPath, the
+// volatile properties such as published, and headers such as Date are not
+// compared. Redacted Authorization headers are sent as the bearer token.
+//
+// The server behind h should make its requests with a RecordingClient, given
+// to SetReportMux with WithHTTPClient, lest the replay deliver activities to
+// the recorded recipients again.
+func ReplayTraffic(h http.Handler, newPath, token string, records []TrafficRecord) []ReplayResult {
+	newIdRegexp := regexp.MustCompile(regexp.QuoteMeta(strings.TrimSuffix(newPath, "/")) + `/[0-9]+`)
+	results := make([]ReplayResult, 0, len(records))
+	for _, t := range records {
+		r := httptest.NewRequest(t.Method, t.URL, strings.NewReader(t.RequestBody))
+		for k, v := range t.RequestHeaders {
+			r.Header[k] = append([]string(nil), v...)
+		}
+		if r.Header.Get("Authorization") == redacted {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		res := ReplayResult{
+			Record: t,
+			Status: w.Code,
+			Body:   w.Body.String(),
+		}
+		if res.Status != t.Status {
+			res.Differences = append(res.Differences, fmt.Sprintf("status: %d != %d", t.Status, res.Status))
+		}
+		for _, k := range comparedHeaders {
+			was := newIdRegexp.ReplaceAllString(t.ResponseHeaders.Get(k), "{new}")
+			is := newIdRegexp.ReplaceAllString(w.Header().Get(k), "{new}")
+			if was != is {
+				res.Differences = append(res.Differences, fmt.Sprintf("header %s: %q != %q", k, was, is))
+			}
+		}
+		res.Differences = append(res.Differences, diffBodies(newIdRegexp, t.ResponseBody, res.Body)...)
+		results = append(results, res)
+	}
+	return results
+}
+
+// diffBodies compares two response bodies, as JSON if both are.
+func diffBodies(newIdRegexp *regexp.Regexp, was, is string) []string {
+	var wasJSON, isJSON interface{}
+	if json.Unmarshal([]byte(was), &wasJSON) != nil || json.Unmarshal([]byte(is), &isJSON) != nil {
+		was = newIdRegexp.ReplaceAllString(was, "{new}")
+		is = newIdRegexp.ReplaceAllString(is, "{new}")
+		if was != is {
+			return []string{fmt.Sprintf("body: %q != %q", was, is)}
+		}
+		return nil
+	}
+	return diffJSON("body", normalizeJSON(newIdRegexp, wasJSON), normalizeJSON(newIdRegexp, isJSON))
+}
+
+// normalizeJSON removes the volatile properties of v and replaces the ids of
+// new objects with a placeholder.
+func normalizeJSON(newIdRegexp *regexp.Regexp, v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			if !volatileProperties[k] {
+				m[k] = normalizeJSON(newIdRegexp, e)
+			}
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			a[i] = normalizeJSON(newIdRegexp, e)
+		}
+		return a
+	case string:
+		return newIdRegexp.ReplaceAllString(t, "{new}")
+	default:
+		return v
+	}
+}
+
+// diffJSON describes the differences between two normalized JSON values,
+// naming where each is found from path.
+func diffJSON(path string, was, is interface{}) []string {
+	switch w := was.(type) {
+	case map[string]interface{}:
+		i, ok := is.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range w {
+			keys[k] = true
+		}
+		for k := range i {
+			keys[k] = true
+		}
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var diffs []string
+		for _, k := range sorted {
+			diffs = append(diffs, diffJSON(path+"."+k, w[k], i[k])...)
+		}
+		return diffs
+	case []interface{}:
+		i, ok := is.([]interface{})
+		if !ok || len(i) != len(w) {
+			break
+		}
+		var diffs []string
+		for n := range w {
+			diffs = append(diffs, diffJSON(fmt.Sprintf("%s[%d]", path, n), w[n], i[n])...)
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(was, is) {
+		return nil
+	}
+	wasB, _ := json.Marshal(was)
+	isB, _ := json.Marshal(is)
+	return []string{fmt.Sprintf("%s: %s != %s", path, wasB, isB)}
+}
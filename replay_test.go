@@ -0,0 +1,127 @@
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notesServer creates a Note at /new/N, numbering from next, for each POST to
+// the outbox, and serves the Notes with the time they were published.
+func notesServer(next int, content string) http.Handler {
+	mu := &sync.Mutex{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/users/alice/outbox":
+			if r.Header.Get("Authorization") != "Bearer t0ken" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Location", fmt.Sprintf("https://example.com/new/%d", next))
+			w.WriteHeader(http.StatusCreated)
+			next++
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/new/"):
+			w.Header().Set("Content-Type", activityJSONType)
+			fmt.Fprintf(w, `{"id": "https://example.com%s", "type": "Note", "content": %q, "published": %q, "tag": [{"href": "https://example.com%s"}]}`,
+				r.URL.Path, content, time.Now().Format(time.RFC3339Nano), r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func replayRecords() []TrafficRecord {
+	return []TrafficRecord{
+		{
+			Method:          http.MethodPost,
+			URL:             "https://example.com/users/alice/outbox",
+			RequestHeaders:  http.Header{"Authorization": {redacted}},
+			RequestBody:     `{"type": "Note"}`,
+			Status:          http.StatusCreated,
+			ResponseHeaders: http.Header{"Location": {"https://example.com/new/7"}, "Date": {"Tue, 01 Jan 2019 00:00:00 GMT"}},
+		},
+		{
+			Method:          http.MethodGet,
+			URL:             "https://example.com/new/7",
+			Status:          http.StatusOK,
+			ResponseHeaders: http.Header{"Content-Type": {activityJSONType}},
+			ResponseBody:    `{"id": "https://example.com/new/7", "type": "Note", "content": "hello", "published": "2019-01-01T00:00:00Z", "tag": [{"href": "https://example.com/new/7"}]}`,
+		},
+	}
+}
+
+func TestReplayTrafficNormalizes(t *testing.T) {
+	// The replay creates /new/1 where the recording created /new/7, and
+	// publishes it now.
+	results := ReplayTraffic(notesServer(1, "hello"), "/new/", "t0ken", replayRecords())
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, res := range results {
+		if len(res.Differences) > 0 {
+			t.Errorf("%s %s differs: %v", res.Record.Method, res.Record.URL, res.Differences)
+		}
+	}
+}
+
+func TestReplayTrafficDiffers(t *testing.T) {
+	results := ReplayTraffic(notesServer(1, "goodbye"), "/new/", "t0ken", replayRecords())
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if want := []string{`body.content: "hello" != "goodbye"`}; strings.Join(results[1].Differences, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got differences %q, want %q", results[1].Differences, want)
+	}
+
+	// A wrong token is not allowed to post.
+	results = ReplayTraffic(notesServer(1, "hello"), "/new/", "guess", replayRecords()[:1])
+	if want := "status: 201 != 401"; len(results[0].Differences) == 0 || results[0].Differences[0] != want {
+		t.Errorf("got differences %q, want %q first", results[0].Differences, want)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		was, is interface{}
+		want    []string
+	}{
+		{"equal", map[string]interface{}{"a": []interface{}{"x", 1.0}}, map[string]interface{}{"a": []interface{}{"x", 1.0}}, nil},
+		{"changed", map[string]interface{}{"a": "x"}, map[string]interface{}{"a": "y"}, []string{`body.a: "x" != "y"`}},
+		{"missing", map[string]interface{}{"a": "x", "b": "y"}, map[string]interface{}{"b": "y"}, []string{`body.a: "x" != null`}},
+		{"array element", []interface{}{"x", "y"}, []interface{}{"x", "z"}, []string{`body[1]: "y" != "z"`}},
+		{"array length", []interface{}{"x"}, []interface{}{"x", "y"}, []string{`body: ["x"] != ["x","y"]`}},
+	} {
+		if got := diffJSON("body", test.was, test.is); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRecordingClient(t *testing.T) {
+	c := NewRecordingClient()
+	for _, test := range []struct {
+		method string
+		want   int
+	}{
+		{http.MethodPost, http.StatusOK},
+		{http.MethodGet, http.StatusNotFound},
+	} {
+		resp, err := c.Do(httptest.NewRequest(test.method, "https://peer.example/inbox", strings.NewReader("{}")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.want {
+			t.Errorf("%s: got %d, want %d", test.method, resp.StatusCode, test.want)
+		}
+	}
+	if got := c.Requests(); len(got) != 2 || got[0].Method != http.MethodPost || got[0].URL != "https://peer.example/inbox" {
+		t.Errorf("recorded %v", got)
+	}
+}
@@ -2,28 +2,187 @@ package report
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"github.com/go-fed/activity/pub"
-	"github.com/go-fed/activity/vocab"
 	"log"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 )
 
 const (
-	actorPath     = "/actor"
-	inboxPath     = "/actor/inbox"
-	outboxPath    = "/actor/outbox"
-	followingPath = "/actor/following"
-	followersPath = "/actor/followers"
-	likedPath     = "/actor/liked"
-	authPath      = "/auth"
-	tokenPath     = "/token"
+	authPath  = "/auth"
+	tokenPath = "/token"
 )
 
+// Option configures optional parts of the server built by SetReportMux.
+type Option func(*options)
+
+type options struct {
+	store        Store
+	privKey      crypto.PrivateKey
+	keyTTL       time.Duration
+	actors       []ActorConfig
+	adminToken   string
+	pageSize     int
+	queue        *DeliveryQueueConfig
+	callbacks    *CallbackRecorder
+	clock        *TestClock
+	oauth        *OAuthConfig
+	sigMode      string
+	clockSkew    time.Duration
+	followPolicy string
+	blocked      []string
+	forwardAll   bool
+	forwardLimit int
+	client       pub.HttpClient
+	version      string
+}
+
+// WithStore keeps the server's objects in s instead of the default in-memory
+// Store.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithPrivateKey makes the default actor sign with privKey, which must be an
+// RSA key, instead of a key generated anew on every start. See
+// LoadOrGenerateKey. It has no effect when WithActors is used.
+func WithPrivateKey(privKey crypto.PrivateKey) Option {
+	return func(o *options) {
+		o.privKey = privKey
+	}
+}
+
+// WithPublicKeyTTL sets how long the public keys of peers are cached before
+// they are fetched again. Defaults to an hour.
+func WithPublicKeyTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.keyTTL = d
+	}
+}
+
+// WithActors hosts the given actors instead of the single default actor,
+// named "report" and authorized by the token
+// "doNotDoThisInRealImplementations". See LoadActorConfigs.
+func WithActors(actors ...ActorConfig) Option {
+	return func(o *options) {
+		o.actors = append(o.actors, actors...)
+	}
+}
+
+// WithAdminToken enables the administrative endpoints under /admin/, such as
+// the one creating actors, for requests bearing token. They are disabled by
+// default.
+func WithAdminToken(token string) Option {
+	return func(o *options) {
+		o.adminToken = token
+	}
+}
+
+// WithPageSize sets the number of items in each page of a collection.
+// Defaults to 20.
+func WithPageSize(n int) Option {
+	return func(o *options) {
+		o.pageSize = n
+	}
+}
+
+// WithDeliveryQueue delivers activities in the background, retrying failed
+// deliveries, instead of once while handling the request that caused them.
+func WithDeliveryQueue(cfg DeliveryQueueConfig) Option {
+	return func(o *options) {
+		o.queue = &cfg
+	}
+}
+
+// WithCallbackRecorder records the callbacks made by go-fed/activity in r, so
+// tests can see which callbacks each request caused. Otherwise the callbacks
+// are recorded only for the /admin/callbacks endpoint.
+func WithCallbackRecorder(r *CallbackRecorder) Option {
+	return func(o *options) {
+		o.callbacks = r
+	}
+}
+
+// WithTestClock makes the server tell time with c, which administrators can
+// also set at /admin/clock.
+func WithTestClock(c *TestClock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithOAuth authorizes clients with an OAuth 2.0 server supporting the
+// authorization code grant with PKCE, instead of the constant token of each
+// actor. Actors consent by logging in with their name and token.
+func WithOAuth(cfg OAuthConfig) Option {
+	return func(o *options) {
+		o.oauth = &cfg
+	}
+}
+
+// WithSignatureVerification sets how the HTTP signatures of inbox POSTs are
+// verified: SignaturesOff, SignaturesLog or SignaturesEnforce, the default
+// being SignaturesLog. The Date of a signed request may be up to skew away
+// from the server's clock, or five minutes if skew is not positive.
+func WithSignatureVerification(mode string, skew time.Duration) Option {
+	return func(o *options) {
+		o.sigMode = mode
+		o.clockSkew = skew
+	}
+}
+
+// WithFollowPolicy sets what becomes of Follows of the hosted actors:
+// FollowsAccept, the default, FollowsReject, or FollowsManual to hold them
+// until accepted or rejected at /admin/follows, which needs WithAdminToken.
+func WithFollowPolicy(policy string) Option {
+	return func(o *options) {
+		o.followPolicy = policy
+	}
+}
+
+// WithBlockedDomains refuses activities from, and skips deliveries to, the
+// given domains and their subdomains. More can be blocked at /admin/blocks
+// given WithAdminToken.
+func WithBlockedDomains(domains ...string) Option {
+	return func(o *options) {
+		o.blocked = append(o.blocked, domains...)
+	}
+}
+
+// WithForwarding sets how many activities of each actor are forwarded from
+// the inboxes per minute, or 30 if limit is not positive. With all, every
+// activity is forwarded to every recipient it is given for, ignoring the rules
+// of ActivityPub and the limit, which turns the server into a spambot but is
+// needed by some report runs.
+func WithForwarding(limit int, all bool) Option {
+	return func(o *options) {
+		o.forwardLimit = limit
+		o.forwardAll = all
+	}
+}
+
+// WithHTTPClient makes the requests of the server, such as deliveries and
+// fetches of public keys, with c instead of an http.Client.
+func WithHTTPClient(c pub.HttpClient) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithActivityVersion reports version as the version of go-fed/activity under
+// test in NodeInfo, instead of ActivityVersion.
+func WithActivityVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
 // SetReportMux builds a basic Social API and Federate API server using the
 // bare-bones go-fed/activity library. Due to the test suite, a skeleton
 // SocialAPIVerifier is used to stub out the OAuth 2 calls but this server does
@@ -32,70 +191,125 @@ const (
 // used as a reference for building an actual implementation.
 //
 // You have been thoroughly warned.
-func SetReportMux(m *http.ServeMux, scheme, host, newPath string) error {
+func SetReportMux(m *http.ServeMux, scheme, host, newPath string, opts ...Option) error {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.store == nil {
+		o.store = NewMemoryStore()
+	}
+	if o.callbacks == nil {
+		o.callbacks = NewCallbackRecorder(defaultCallbackHistorySize)
+	}
+	if len(o.sigMode) == 0 {
+		o.sigMode = SignaturesLog
+	} else if !validSignatureMode(o.sigMode) {
+		return fmt.Errorf("unknown signature mode %q", o.sigMode)
+	}
+	if len(o.followPolicy) == 0 {
+		o.followPolicy = FollowsAccept
+	} else if !validFollowPolicy(o.followPolicy) {
+		return fmt.Errorf("unknown follow policy %q", o.followPolicy)
+	} else if o.followPolicy == FollowsManual && len(o.adminToken) == 0 {
+		return fmt.Errorf("follow policy %q needs an admin token", o.followPolicy)
+	}
+	if o.clockSkew <= 0 {
+		o.clockSkew = defaultClockSkew
+	}
+	if o.pageSize <= 0 {
+		o.pageSize = defaultPageSize
+	}
+	if len(o.version) == 0 {
+		o.version = ActivityVersion()
+	}
+	if len(o.actors) == 0 {
+		o.actors = []ActorConfig{{
+			Name:        defaultActorName,
+			DisplayName: "Implementation Report Account",
+			Token:       defaultActorToken,
+			privKey:     o.privKey,
+		}}
+	}
+
 	// Implementation specific data
-	actorURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, actorPath))
+	authURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, authPath))
 	if err != nil {
 		return err
 	}
-	inboxURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, inboxPath))
+	tokenURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, tokenPath))
 	if err != nil {
 		return err
 	}
-	outboxURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, outboxPath))
-	if err != nil {
-		return err
+
+	// Prepare basic implementation
+	stub := &doNotUseThisItIsNotOAuth{}
+	var verifier pub.SocialAPIVerifier = stub
+	authorizeFn := stub.AuthorizeRequestWithoutActuallyDoingAnything
+	tokenFn := stub.GrantBearerTokenWithoutActuallyDoingAnything
+	var clock pub.Clock = &localClock{}
+	if o.clock != nil {
+		clock = o.clock
 	}
-	followingURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, followingPath))
-	if err != nil {
-		return err
+	var oauth *oauthServer
+	if o.oauth != nil {
+		if oauth, err = newOAuthServer(*o.oauth, clock); err != nil {
+			return err
+		}
+		verifier = oauth
+		authorizeFn = oauth.serveAuthorize
+		tokenFn = oauth.serveToken
 	}
-	followersURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, followersPath))
-	if err != nil {
-		return err
+	history := newDeliveryHistory(defaultDeliveryHistorySize)
+	var client pub.HttpClient = &http.Client{}
+	if o.client != nil {
+		client = o.client
 	}
-	likedURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, likedPath))
-	if err != nil {
+	httpClient := &historyClient{client: client, history: history}
+	app := newApp(scheme, host, newPath, o.store, authURL, tokenURL, verifier, httpClient, clock, o.keyTTL, o.pageSize)
+	app.signatureMode = o.sigMode
+	app.clockSkew = o.clockSkew
+	app.followPolicy = o.followPolicy
+	app.blocks = newBlocklist(o.blocked)
+	app.forwarding = newForwardingLimiter(o.forwardLimit, forwardingWindow)
+	app.permissiveForwarding = o.forwardAll
+	app.activityVersion = o.version
+	stub.app = app
+	if oauth != nil {
+		oauth.app = app
+	}
+	for _, cfg := range o.actors {
+		if _, err := app.addActor(context.Background(), cfg); err != nil {
+			return err
+		}
+	}
+	if err := app.restoreCreatedActors(context.Background()); err != nil {
 		return err
 	}
-	authURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, authPath))
-	if err != nil {
+	if err := app.restoreBlocks(context.Background()); err != nil {
 		return err
 	}
-	tokenURL, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, tokenPath))
-	if err != nil {
+	if err := app.restoreBlockedDomains(context.Background()); err != nil {
 		return err
 	}
-	privKey, err := rsa.GenerateKey(rand.Reader, 1024)
-	if err != nil {
+	if err := app.restorePendingFollows(context.Background()); err != nil {
 		return err
 	}
-	pubKey := privKey.Public()
-	endpoints := &vocab.Object{}
-	endpoints.SetOauthAuthorizationEndpoint(authURL)
-	endpoints.SetOauthTokenEndpoint(tokenURL)
-	actor := &vocab.Person{}
-	actor.SetEndpoints(endpoints)
-	actor.SetId(actorURL)
-	actor.AppendNameString("Implementation Report Account")
-	actor.SetOutboxAnyURI(outboxURL)
-	actor.SetInboxAnyURI(inboxURL)
-	actor.SetFollowingAnyURI(followingURL)
-	actor.SetFollowersAnyURI(followersURL)
-	actor.SetLikedAnyURI(likedURL)
-	actor.SetPreferredUsername("Implementation Report Account")
-
-	// Prepare basic implementation
-	verifier := &doNotUseThisItIsNotOAuth{
-		ActorURL:  actorURL,
-		OutboxURL: outboxURL,
-	}
-	app := newApp(scheme, host, newPath, actorURL, inboxURL, outboxURL, followingURL, followersURL, likedURL, pubKey, privKey, actor, verifier)
-	fedCb := &nothingCallbacker{}
-	socialCb := &nothingCallbacker{}
-	clock := &localClock{}
-	deliverer := &syncDeliverer{}
-	pubber := pub.NewPubber(clock, app, socialCb, fedCb, deliverer, &http.Client{}, "go-fed-report", 5, 5)
+	app.blocks.save = app.saveBlockedDomains
+	app.follows.save = app.savePendingFollows
+	fedCb := &recordingCallbacker{side: FederatedSide, rec: o.callbacks, next: &nothingCallbacker{}}
+	socialCb := &recordingCallbacker{side: SocialSide, rec: o.callbacks, next: &blockingCallbacker{Callbacker: &nothingCallbacker{}, app: app}}
+	var deliverer pub.Deliverer = &syncDeliverer{history: history}
+	var queue *queueDeliverer
+	if o.queue != nil {
+		if queue, err = newQueueDeliverer(*o.queue, history, app.deliver, app.blocks); err != nil {
+			return err
+		}
+		deliverer = queue
+	}
+	deliverer = &blockingDeliverer{blocks: app.blocks, next: deliverer}
+	app.deliverer = deliverer
+	pubber := pub.NewPubber(clock, app, socialCb, fedCb, deliverer, httpClient, "go-fed-report", 5, 5)
 	serveFn := pub.ServeActivityPubObject(app, clock)
 	addMissingFn := func(r *http.Request) {
 		r.URL.Host = host
@@ -105,103 +319,139 @@ func SetReportMux(m *http.ServeMux, scheme, host, newPath string) error {
 	// Set up sync primitives
 	lockKey := 1
 	lockKeyMu := &sync.Mutex{}
-	getLockKeySafely := func() (context.Context, context.CancelFunc) {
+	getLockKeySafely := func(r *http.Request) (context.Context, context.CancelFunc) {
 		c := context.Background()
-		k := lockKeyType("lockKey")
 		lockKeyMu.Lock()
 		defer lockKeyMu.Unlock()
 		v := lockKey
 		lockKey++
-		return context.WithCancel(context.WithValue(c, k, v))
+		recordLockKey(r, v)
+		return context.WithCancel(context.WithValue(c, lockKeyName, v))
 	}
 	// Set up handlers
 	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		addMissingFn(r)
 		log.Printf("received request to %q", r.URL)
-		c, cfn := getLockKeySafely()
+		c, cfn := getLockKeySafely(r)
 		defer cfn()
-		if handled, err := serveFn(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
+		w, flush := filterBlindRecipients(w, r)
+		defer flush()
+		for _, h := range []pub.HandlerFunc{app.checkAccess, serveFn} {
+			if handled, err := h(c, w, r); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Print(err)
+				return
+			} else if handled {
+				return
+			}
 		}
 		log.Printf("request to %q not an activitypub request", r.URL)
 		log.Print(r)
 		w.WriteHeader(http.StatusNotFound)
 	})
-	m.HandleFunc(actorPath, func(w http.ResponseWriter, r *http.Request) {
+	m.HandleFunc(usersPath, func(w http.ResponseWriter, r *http.Request) {
 		addMissingFn(r)
 		log.Printf("received request to %q", r.URL)
-		c, cfn := getLockKeySafely()
+		c, cfn := getLockKeySafely(r)
 		defer cfn()
-		if handled, err := serveFn(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
+		w, flush := filterBlindRecipients(w, r)
+		defer flush()
+		var handlers []pub.HandlerFunc
+		if l, ok := app.actorForBox(r.URL); !ok {
+			handlers = []pub.HandlerFunc{app.serveCollection, app.checkAccess, serveFn}
+		} else if *r.URL == *l.inboxURL {
+			handlers = []pub.HandlerFunc{app.serveCollection, pubber.GetInbox, app.verifyInbox, pubber.PostInbox}
+		} else {
+			handlers = []pub.HandlerFunc{app.serveCollection, pubber.GetOutbox, pubber.PostOutbox}
+		}
+		for _, h := range handlers {
+			if handled, err := h(c, w, r); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Print(err)
+				return
+			} else if handled {
+				return
+			}
 		}
 		log.Printf("request to %q not an activitypub request", r.URL)
 		log.Print(r)
 		w.WriteHeader(http.StatusNotFound)
 	})
-	m.HandleFunc(inboxPath, func(w http.ResponseWriter, r *http.Request) {
+	for _, p := range []string{legacyActorPath, legacyActorPath + "/"} {
+		m.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received request to %q", r.URL)
+			app.serveLegacyActor(w, r)
+		})
+	}
+	m.HandleFunc(authPath, func(w http.ResponseWriter, r *http.Request) {
 		addMissingFn(r)
 		log.Printf("received request to %q", r.URL)
-		c, cfn := getLockKeySafely()
-		defer cfn()
-		if handled, err := pubber.GetInbox(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
-		}
-		if handled, err := pubber.PostInbox(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
-		}
-		log.Printf("request to %q not an activitypub request", r.URL)
-		log.Print(r)
-		w.WriteHeader(http.StatusNotFound)
+		authorizeFn(w, r)
 	})
-	m.HandleFunc(outboxPath, func(w http.ResponseWriter, r *http.Request) {
+	m.HandleFunc(tokenPath, func(w http.ResponseWriter, r *http.Request) {
 		addMissingFn(r)
 		log.Printf("received request to %q", r.URL)
-		c, cfn := getLockKeySafely()
-		defer cfn()
-		if handled, err := pubber.GetOutbox(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
-		}
-		if handled, err := pubber.PostOutbox(c, w, r); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Print(err)
-			return
-		} else if handled {
-			return
-		}
-		log.Printf("request to %q not an activitypub request", r.URL)
-		log.Print(r)
-		w.WriteHeader(http.StatusNotFound)
+		tokenFn(w, r)
 	})
-	m.HandleFunc(authPath, func(w http.ResponseWriter, r *http.Request) {
-		addMissingFn(r)
+	if oauth != nil {
+		m.HandleFunc(revokePath, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received request to %q", r.URL)
+			oauth.serveRevoke(w, r)
+		})
+	}
+	m.HandleFunc(webFingerPath, func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("received request to %q", r.URL)
-		verifier.AuthorizeRequestWithoutActuallyDoingAnything(w, r)
+		app.serveWebFinger(w, r)
 	})
-	m.HandleFunc(tokenPath, func(w http.ResponseWriter, r *http.Request) {
-		addMissingFn(r)
+	m.HandleFunc(hostMetaPath, func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("received request to %q", r.URL)
-		verifier.GrantBearerTokenWithoutActuallyDoingAnything(w, r)
+		app.serveHostMeta(w, r)
 	})
+	m.HandleFunc(nodeInfoLinksPath, func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("received request to %q", r.URL)
+		app.serveNodeInfoLinks(w, r)
+	})
+	m.HandleFunc(nodeInfoPath, func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("received request to %q", r.URL)
+		app.serveNodeInfo(w, r)
+	})
+	if len(o.adminToken) > 0 {
+		m.HandleFunc(adminActorsPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+			addMissingFn(r)
+			log.Printf("received request to %q", r.URL)
+			app.serveAdminActors(w, r)
+		}))
+		m.HandleFunc(adminDeliveriesPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received request to %q", r.URL)
+			serveAdminDeliveries(w, r, history, queue)
+		}))
+		m.HandleFunc(adminCallbacksPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received request to %q", r.URL)
+			o.callbacks.serveAdmin(w, r)
+		}))
+		m.HandleFunc(adminBlocksPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received request to %q", r.URL)
+			app.serveAdminBlocks(w, r)
+		}))
+		m.HandleFunc(adminFollowsPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+			addMissingFn(r)
+			log.Printf("received request to %q", r.URL)
+			c, cfn := getLockKeySafely(r)
+			defer cfn()
+			app.serveAdminFollows(c, w, r)
+		}))
+		if oauth != nil {
+			m.HandleFunc(adminOAuthClientsPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("received request to %q", r.URL)
+				oauth.serveAdminClients(w, r)
+			}))
+		}
+		if o.clock != nil {
+			m.HandleFunc(adminClockPath, requireAdminToken(o.adminToken, func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("received request to %q", r.URL)
+				o.clock.serveAdmin(w, r)
+			}))
+		}
+	}
 	return nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-fed/report"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// reportMain writes the implementation report for the results saved by
+// "repsrv selftest -results".
+func reportMain(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	resultsFile := fs.String("results", "results.json", "JSON file with the results of \"repsrv selftest\"")
+	format := fs.String("format", "html", "format of the report: html, json, earl (Turtle) or earl-jsonld")
+	out := fs.String("out", "", "file to write the report to; standard output if empty")
+	version := fs.String("version", "", "version of go-fed/activity under test; the one the tested server reported if empty")
+	fs.Parse(args)
+
+	b, err := ioutil.ReadFile(*resultsFile)
+	if err != nil {
+		panic(err)
+	}
+	results, err := report.ReadSelfTestResults(b)
+	if err != nil {
+		panic(err)
+	}
+	if len(*version) > 0 {
+		results.Version = *version
+	}
+	r := report.NewImplementationReport(results.Version, results.Results)
+
+	var write func(io.Writer) error
+	switch *format {
+	case "html":
+		write = r.WriteHTML
+	case "json":
+		write = r.WriteJSON
+	case "earl":
+		write = r.WriteEARLTurtle
+	case "earl-jsonld":
+		write = r.WriteEARLJSONLD
+	default:
+		panic(fmt.Errorf("unknown report format %q", *format))
+	}
+	w := io.Writer(os.Stdout)
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"github.com/go-fed/report"
+	"log"
+	"net/http"
+)
+
+// mockPeerMain runs a fake remote peer recording everything delivered to it,
+// standing in for a test account on another server.
+func mockPeerMain(args []string) {
+	fs := flag.NewFlagSet("mockpeer", flag.ExitOnError)
+	addr := fs.String("addr", ":8081", "address to listen on")
+	host := fs.String("host", "localhost:8081", "host of the peer as seen by the report server")
+	name := fs.String("name", "test", "name of the peer's actor")
+	fs.Parse(args)
+
+	p, err := report.NewMockPeer(httpScheme, *host, *name)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("mock peer actor is %s", p.ActorIRI())
+	if err := http.ListenAndServe(*addr, p); err != http.ErrServerClosed {
+		panic(err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-fed/report"
+	"net/http"
+	"os"
+)
+
+// replayMain replays a session recorded with -record against a fresh server
+// and prints the responses that differ from the recorded ones.
+func replayMain(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	https := fs.Bool("https", false, "whether the recorded server served via https")
+	host := fs.String("host", "", "host domain of the recorded server")
+	newPath := fs.String("newPath", "/new", "path to newly created items")
+	actorsFile := fs.String("actors", "", "JSON file listing the actors the recorded server hosted; a single default actor if empty")
+	token := fs.String("token", "doNotDoThisInRealImplementations", "bearer token to send in place of redacted Authorization headers")
+	pageSize := fs.Int("pageSize", 20, "number of items in each page of a collection")
+	clockSpec := fs.String("clock", "", "time to freeze the clock at, in RFC 3339, or a signed duration to offset it by")
+	signatures := fs.String("signatures", report.SignaturesOff, "verification of signatures on inbox POSTs: off, log or enforce; recorded signatures have usually expired")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: repsrv replay [flags] recording.jsonl")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	records, err := report.ReadTrafficRecords(f)
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+	scheme := httpScheme
+	if *https {
+		scheme = httpsScheme
+	}
+	// Requests the server makes, such as deliveries, are not sent.
+	client := report.NewRecordingClient()
+	opts := []report.Option{
+		report.WithPageSize(*pageSize),
+		report.WithHTTPClient(client),
+		report.WithSignatureVerification(*signatures, 0),
+	}
+	if len(*actorsFile) > 0 {
+		actors, err := report.LoadActorConfigs(*actorsFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, report.WithActors(actors...))
+	}
+	if len(*clockSpec) > 0 {
+		clock, err := report.ParseTestClock(*clockSpec)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, report.WithTestClock(clock))
+	}
+	mux := http.NewServeMux()
+	if err := report.SetReportMux(mux, scheme, *host, *newPath, opts...); err != nil {
+		panic(err)
+	}
+
+	differ := 0
+	for _, res := range report.ReplayTraffic(mux, *newPath, *token, records) {
+		if len(res.Differences) == 0 {
+			continue
+		}
+		differ++
+		fmt.Printf("%s %s (recorded %s)\n", res.Record.Method, res.Record.URL, res.Record.Started.Format("2006-01-02T15:04:05.000Z07:00"))
+		for _, d := range res.Differences {
+			fmt.Printf("\t%s\n", d)
+		}
+	}
+	for _, u := range client.Requests() {
+		fmt.Printf("not sent: %s %s\n", u.Method, u.URL)
+	}
+	fmt.Printf("%d of %d responses differ\n", differ, len(records))
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto"
 	"crypto/tls"
 	"flag"
 	"github.com/go-fed/report"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -15,10 +19,50 @@ const (
 var https *bool = flag.Bool("https", false, "enable serving via https")
 var host *string = flag.String("host", "", "host domain of the server")
 var newPath *string = flag.String("newPath", "/new", "path to newly created items")
+var addr *string = flag.String("addr", "", "address to listen on; defaults to the port of the scheme")
 var certFile *string = flag.String("cert", "", "tls cert file")
 var keyFile *string = flag.String("key", "", "tls key file")
+var actorsFile *string = flag.String("actors", "", "JSON file listing the actors to host; a single default actor is hosted if empty")
+var adminToken *string = flag.String("adminToken", "", "bearer token for the /admin/ endpoints; disabled if empty")
+var actorKeyFile *string = flag.String("actorKey", "", "PEM file with the default actor's private key, created if missing; a new key is used on each start if empty")
+var actorKeyBits *int = flag.Int("actorKeyBits", 2048, "size in bits of a created RSA actor key")
+var pageSize *int = flag.Int("pageSize", 20, "number of items in each page of a collection")
+var recordFile *string = flag.String("record", "", "JSONL file to append every request and response to")
+var queue *bool = flag.Bool("queue", false, "deliver in the background, retrying failed deliveries")
+var queueDir *string = flag.String("queueDir", "", "directory keeping pending deliveries across restarts; implies -queue")
+var maxAttempts *int = flag.Int("maxAttempts", 8, "number of attempts before a queued delivery is given up on")
+var clockSpec *string = flag.String("clock", "", "time to freeze the clock at, in RFC 3339, or a signed duration such as +1h to offset it by; settable at /admin/clock if given")
+var clockStep *time.Duration = flag.Duration("clockStep", 0, "how far a frozen clock moves forward each time it is read; freezes the clock at the start time if -clock is not given")
+var oauthClients *string = flag.String("oauthClients", "", "JSON file listing the registered OAuth clients; enables the OAuth 2.0 server instead of constant tokens")
+var oauthTokenTTL *time.Duration = flag.Duration("oauthTokenTTL", time.Hour, "how long OAuth access tokens are valid")
+var signatures *string = flag.String("signatures", report.SignaturesLog, "verification of signatures on inbox POSTs: off, log or enforce")
+var follows *string = flag.String("follows", report.FollowsAccept, "what becomes of Follows: accept, reject or manual to decide at /admin/follows")
+var blockDomains *string = flag.String("blockDomains", "", "comma-separated domains to refuse activities from and not deliver to")
+var forwardAll *bool = flag.Bool("forwardAll", false, "forward every activity received in an inbox, ignoring the rules of ActivityPub")
+var forwardLimit *int = flag.Int("forwardLimit", 30, "activities of each actor forwarded per minute")
+var clockSkew *time.Duration = flag.Duration("clockSkew", 5*time.Minute, "how far the date of a signed request may be from the server's clock")
+var activityVersion *string = flag.String("activityVersion", report.ActivityVersion(), "version of go-fed/activity under test, reported in NodeInfo")
+var dataDir *string = flag.String("data", "", "directory keeping objects across restarts; kept in memory only if empty")
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "mockpeer":
+			mockPeerMain(os.Args[2:])
+			return
+		case "selftest":
+			selfTestMain(os.Args[2:])
+			return
+		case "report":
+			reportMain(os.Args[2:])
+			return
+		case "replay":
+			replayMain(os.Args[2:])
+			return
+		}
+	}
+
 	// Flags
 	flag.Parse()
 	scheme := httpScheme
@@ -27,8 +71,74 @@ func main() {
 	}
 
 	// Server set up
+	opts := []report.Option{
+		report.WithPageSize(*pageSize),
+		report.WithSignatureVerification(*signatures, *clockSkew),
+		report.WithFollowPolicy(*follows),
+		report.WithForwarding(*forwardLimit, *forwardAll),
+		report.WithActivityVersion(*activityVersion),
+	}
+	if len(*dataDir) > 0 {
+		store, err := report.NewFileStore(*dataDir)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, report.WithStore(store))
+	}
+	if len(*actorsFile) > 0 {
+		actors, err := report.LoadActorConfigs(*actorsFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, report.WithActors(actors...))
+	}
+	if len(*blockDomains) > 0 {
+		opts = append(opts, report.WithBlockedDomains(strings.Split(*blockDomains, ",")...))
+	}
+	if *queue || len(*queueDir) > 0 {
+		opts = append(opts, report.WithDeliveryQueue(report.DeliveryQueueConfig{
+			MaxAttempts: *maxAttempts,
+			Dir:         *queueDir,
+		}))
+	}
+	if len(*clockSpec) > 0 || *clockStep > 0 {
+		clock, err := report.ParseTestClock(*clockSpec)
+		if err != nil {
+			panic(err)
+		}
+		if len(*clockSpec) == 0 {
+			// Only a frozen clock steps.
+			clock.Freeze(time.Now())
+		}
+		clock.SetStep(*clockStep)
+		opts = append(opts, report.WithTestClock(clock))
+	}
+	if len(*oauthClients) > 0 {
+		clients, err := report.LoadOAuthClients(*oauthClients)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, report.WithOAuth(report.OAuthConfig{
+			Clients:  clients,
+			TokenTTL: *oauthTokenTTL,
+		}))
+	}
+	if len(*adminToken) > 0 {
+		opts = append(opts, report.WithAdminToken(*adminToken))
+	}
+	var privKey crypto.PrivateKey
+	var err error
+	if len(*actorKeyFile) > 0 {
+		privKey, err = report.LoadOrGenerateKey(*actorKeyFile, *actorKeyBits)
+	} else {
+		privKey, err = report.GenerateKey(*actorKeyBits)
+	}
+	if err != nil {
+		panic(err)
+	}
+	opts = append(opts, report.WithPrivateKey(privKey))
 	mux := http.NewServeMux()
-	err := report.SetReportMux(mux, scheme, *host, *newPath)
+	err = report.SetReportMux(mux, scheme, *host, *newPath, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -36,6 +146,17 @@ func main() {
 		Addr:    ":" + scheme,
 		Handler: mux,
 	}
+	if len(*recordFile) > 0 {
+		f, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		s.Handler = report.NewRecorder(mux, f)
+	}
+	if len(*addr) > 0 {
+		s.Addr = *addr
+	}
 
 	// Run the server
 	if *https {
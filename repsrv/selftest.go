@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/go-fed/report"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"time"
+)
+
+// selfTestMain runs the manual test cases of the README against a running
+// server and prints a table of the results.
+func selfTestMain(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	actor := fs.String("actor", "http://localhost:8080/users/report", "id of the actor under test")
+	token := fs.String("token", "doNotDoThisInRealImplementations", "bearer token of the actor under test")
+	newPath := fs.String("newPath", "/new", "path to newly created items on the server under test")
+	testAccount := fs.String("testAccount", "http://localhost:8081/users/test", "id of the remote actor to deliver to")
+	peer := fs.String("peer", "", "root of the mock peer hosting the test account, e.g. http://localhost:8081; delivery cases are skipped if empty")
+	wait := fs.Duration("wait", 2*time.Second, "how long to wait for deliveries to reach the mock peer")
+	casesFile := fs.String("cases", "", "JSON file with the test cases to run instead of the built-in ones")
+	list := fs.Bool("list", false, "print the test cases as JSON instead of running them")
+	resultsFile := fs.String("results", "", "JSON file to save the results in, for \"repsrv report\"")
+	fs.Parse(args)
+
+	cases := report.DefaultSelfTestCases()
+	if len(*casesFile) > 0 {
+		b, err := ioutil.ReadFile(*casesFile)
+		if err != nil {
+			panic(err)
+		}
+		cases = nil
+		if err := json.Unmarshal(b, &cases); err != nil {
+			panic(err)
+		}
+	}
+	if *list {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(cases); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	actorURL, err := url.Parse(*actor)
+	if err != nil {
+		panic(err)
+	}
+	cfg := report.SelfTestConfig{
+		Actor:        actorURL,
+		Token:        *token,
+		NewPath:      *newPath,
+		TestAccount:  *testAccount,
+		DeliveryWait: *wait,
+	}
+	if len(*peer) > 0 {
+		if cfg.Peer, err = url.Parse(*peer); err != nil {
+			panic(err)
+		}
+	}
+	results, err := report.RunSelfTest(cfg, cases)
+	if err != nil {
+		panic(err)
+	}
+	if err := report.WriteSelfTestTable(os.Stdout, results); err != nil {
+		panic(err)
+	}
+	if len(*resultsFile) > 0 {
+		saved := report.SelfTestResults{Results: results}
+		if saved.Version, err = report.ServerVersion(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot tell the version under test: %s\n", err)
+		}
+		b, err := json.MarshalIndent(saved, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(*resultsFile, b, 0644); err != nil {
+			panic(err)
+		}
+	}
+	for _, r := range results {
+		if !r.Passed && !r.Skipped {
+			os.Exit(1)
+		}
+	}
+}
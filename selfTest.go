@@ -0,0 +1,457 @@
+package report
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SelfTestCase is one of the manual test cases of the README, run by
+// RunSelfTest against a live server.
+//
+// Paths, bodies and checks may refer to variables as {name}: {base} is the
+// scheme and host of the server, {new} is the root of the ids of new objects,
+// {actor}, {inbox}, {outbox}, {followers} and
+// {following} are the ids of the actor under test, {testAccount} is the
+// remote account deliveries are checked against, and {unique} is different on
+// every run. Steps can save more variables with Save and SaveObject.
+type SelfTestCase struct {
+	Name string `json:"name"`
+	// Section of the ActivityPub specification the requirement is from.
+	Section string `json:"section"`
+	// Level of the requirement: MUST, SHOULD or MAY.
+	Level       string         `json:"level"`
+	Requirement string         `json:"requirement"`
+	Steps       []SelfTestStep `json:"steps"`
+	// NeedsPeer marks cases checking deliveries, which are skipped unless
+	// a mock peer is given.
+	NeedsPeer bool `json:"needsPeer,omitempty"`
+}
+
+// SelfTestStep is a request made during a SelfTestCase, and what to check
+// once it has been made.
+type SelfTestStep struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Auth sends the bearer token of the actor under test.
+	Auth bool   `json:"auth,omitempty"`
+	Body string `json:"body,omitempty"`
+	// Status lists the acceptable response codes, either exactly as in
+	// "201" or by class as in "4xx".
+	Status []string `json:"status"`
+	// Save stores the Location of the response in the named variable.
+	Save string `json:"save,omitempty"`
+	// SaveObject fetches the Location of the response and stores the id
+	// of its object in the named variable.
+	SaveObject string          `json:"saveObject,omitempty"`
+	Checks     []SelfTestCheck `json:"checks,omitempty"`
+}
+
+// SelfTestCheck verifies a side effect of a SelfTestStep. Only the checks for
+// which fields are set are made.
+type SelfTestCheck struct {
+	// Fetch is an id to GET, expecting one of FetchStatus and, if set, a
+	// document of type Type.
+	Fetch       string   `json:"fetch,omitempty"`
+	FetchStatus []string `json:"fetchStatus,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	// Collection is an id of a collection expected to hold Contains at
+	// least once and at most AtMost times, if AtMost is set.
+	Collection string `json:"collection,omitempty"`
+	Contains   string `json:"contains,omitempty"`
+	AtMost     *int   `json:"atMost,omitempty"`
+	// Deliveries is the number of deliveries the mock peer must have
+	// received since the case started.
+	Deliveries *int `json:"deliveries,omitempty"`
+}
+
+// SelfTestConfig describes the server RunSelfTest runs against.
+type SelfTestConfig struct {
+	// Actor is the id of the actor under test.
+	Actor *url.URL
+	// Token is the bearer token of the actor.
+	Token string
+	// NewPath is the path of new objects on the server, "/new" if empty.
+	NewPath string
+	// TestAccount is the id of a remote actor deliveries are made to.
+	TestAccount string
+	// Peer is the root of a MockPeer hosting TestAccount. Cases checking
+	// deliveries are skipped if nil.
+	Peer *url.URL
+	// DeliveryWait is how long to wait for deliveries to arrive at the
+	// peer before checking them.
+	DeliveryWait time.Duration
+	Client       *http.Client
+}
+
+// SelfTestResult is the outcome of a SelfTestCase.
+type SelfTestResult struct {
+	Case     SelfTestCase  `json:"case"`
+	Passed   bool          `json:"passed"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// SelfTestResults are the results of a run as saved for making a report, with
+// the version of go-fed/activity the tested server reported.
+type SelfTestResults struct {
+	Version string           `json:"version"`
+	Results []SelfTestResult `json:"results"`
+}
+
+// ReadSelfTestResults reads saved SelfTestResults.
+func ReadSelfTestResults(b []byte) (SelfTestResults, error) {
+	var r SelfTestResults
+	err := json.Unmarshal(b, &r)
+	return r, err
+}
+
+// ServerVersion returns the version of go-fed/activity the server of the actor
+// under test reports in its NodeInfo.
+func ServerVersion(cfg SelfTestConfig) (string, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	s := &selfTestRun{cfg: cfg}
+	u := url.URL{Scheme: cfg.Actor.Scheme, Host: cfg.Actor.Host, Path: nodeInfoPath}
+	m, err := s.fetch(u.String())
+	if err != nil {
+		return "", err
+	}
+	software, _ := m["software"].(map[string]interface{})
+	v := stringValue(software["version"])
+	if len(v) == 0 {
+		return "", fmt.Errorf("%s reports no software version", u.String())
+	}
+	return v, nil
+}
+
+// RunSelfTest runs each case in order against a live server.
+func RunSelfTest(cfg SelfTestConfig, cases []SelfTestCase) ([]SelfTestResult, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.DeliveryWait <= 0 {
+		cfg.DeliveryWait = 2 * time.Second
+	}
+	if len(cfg.NewPath) == 0 {
+		cfg.NewPath = "/new"
+	}
+	s := &selfTestRun{cfg: cfg}
+	actor, err := s.fetch(cfg.Actor.String())
+	if err != nil {
+		return nil, fmt.Errorf("fetching actor: %s", err)
+	}
+	base := fmt.Sprintf("%s://%s", cfg.Actor.Scheme, cfg.Actor.Host)
+	s.vars = map[string]string{
+		"base":        base,
+		"new":         base + "/" + strings.Trim(cfg.NewPath, "/"),
+		"actor":       cfg.Actor.String(),
+		"testAccount": cfg.TestAccount,
+	}
+	for _, prop := range []string{"inbox", "outbox", "followers", "following"} {
+		s.vars[prop] = stringValue(actor[prop])
+	}
+	var results []SelfTestResult
+	for _, tc := range cases {
+		start := time.Now()
+		r := SelfTestResult{Case: tc}
+		if tc.NeedsPeer && (cfg.Peer == nil || len(cfg.TestAccount) == 0) {
+			r.Skipped = true
+			r.Error = "needs a mock peer"
+		} else if err := s.runCase(tc); err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Passed = true
+		}
+		r.Duration = time.Since(start)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// WriteSelfTestTable prints results as a table, followed by a summary line.
+func WriteSelfTestTable(w io.Writer, results []SelfTestResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tLEVEL\tSECTION\tCASE\tDETAILS")
+	var passed, failed, skipped int
+	for _, r := range results {
+		result := "PASS"
+		if r.Skipped {
+			result = "SKIP"
+			skipped++
+		} else if !r.Passed {
+			result = "FAIL"
+			failed++
+		} else {
+			passed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result, r.Case.Level, r.Case.Section, r.Case.Name, r.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return err
+}
+
+type selfTestRun struct {
+	cfg  SelfTestConfig
+	vars map[string]string
+}
+
+func (s *selfTestRun) runCase(tc SelfTestCase) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	s.vars["unique"] = hex.EncodeToString(b)
+	if tc.NeedsPeer {
+		if err := s.resetPeer(); err != nil {
+			return fmt.Errorf("resetting mock peer: %s", err)
+		}
+	}
+	for i, step := range tc.Steps {
+		if err := s.runStep(step); err != nil {
+			return fmt.Errorf("step %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *selfTestRun) runStep(step SelfTestStep) error {
+	var body io.Reader
+	if len(step.Body) > 0 {
+		body = strings.NewReader(s.expand(step.Body))
+	}
+	req, err := http.NewRequest(step.Method, s.expand(step.Path), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", ldJSONType)
+	if body != nil {
+		req.Header.Set("Content-Type", ldJSONType)
+	}
+	if step.Auth {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !statusMatches(resp.StatusCode, step.Status) {
+		return fmt.Errorf("%s %s: got status %d, want %s", step.Method, req.URL, resp.StatusCode, strings.Join(step.Status, " or "))
+	}
+	loc := resp.Header.Get("Location")
+	if len(step.Save) > 0 {
+		if len(loc) == 0 {
+			return fmt.Errorf("no Location to save as %s", step.Save)
+		}
+		s.vars[step.Save] = loc
+	}
+	if len(step.SaveObject) > 0 {
+		m, err := s.fetch(loc)
+		if err != nil {
+			return err
+		}
+		obj := stringValue(m["object"])
+		if om, ok := m["object"].(map[string]interface{}); ok {
+			obj = stringValue(om["id"])
+		}
+		if len(obj) == 0 {
+			return fmt.Errorf("no object id in %s to save as %s", loc, step.SaveObject)
+		}
+		s.vars[step.SaveObject] = obj
+	}
+	for _, check := range step.Checks {
+		if err := s.check(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *selfTestRun) check(c SelfTestCheck) error {
+	if len(c.Fetch) > 0 {
+		id := s.expand(c.Fetch)
+		status, m, err := s.get(id)
+		if err != nil {
+			return err
+		}
+		want := c.FetchStatus
+		if len(want) == 0 {
+			want = []string{"200"}
+		}
+		if !statusMatches(status, want) {
+			return fmt.Errorf("fetching %s: got status %d, want %s", id, status, strings.Join(want, " or "))
+		} else if len(c.Type) > 0 && status == http.StatusOK {
+			if types := typeNames(m["type"]); len(types) == 0 || types[0] != c.Type {
+				return fmt.Errorf("fetching %s: got type %v, want %s", id, m["type"], c.Type)
+			}
+		}
+	}
+	if len(c.Collection) > 0 {
+		coll, want := s.expand(c.Collection), s.expand(c.Contains)
+		n, err := s.countInCollection(coll, want)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%s does not contain %s", coll, want)
+		} else if c.AtMost != nil && n > *c.AtMost {
+			return fmt.Errorf("%s contains %s %d times, want at most %d", coll, want, n, *c.AtMost)
+		}
+	}
+	if c.Deliveries != nil {
+		n, err := s.awaitDeliveries(*c.Deliveries)
+		if err != nil {
+			return err
+		} else if n != *c.Deliveries {
+			return fmt.Errorf("mock peer received %d deliveries, want %d", n, *c.Deliveries)
+		}
+	}
+	return nil
+}
+
+// expand replaces the {name} variables in t.
+func (s *selfTestRun) expand(t string) string {
+	for k, v := range s.vars {
+		t = strings.Replace(t, "{"+k+"}", v, -1)
+	}
+	return t
+}
+
+func (s *selfTestRun) get(id string) (int, map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, id, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", ldJSONType)
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	var m map[string]interface{}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return 0, nil, fmt.Errorf("fetching %s: %s", id, err)
+		}
+	}
+	return resp.StatusCode, m, nil
+}
+
+func (s *selfTestRun) fetch(id string) (map[string]interface{}, error) {
+	status, m, err := s.get(id)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: got status %d", id, status)
+	}
+	return m, nil
+}
+
+// countInCollection counts how often id occurs in a collection, following its
+// pages if it has any.
+func (s *selfTestRun) countInCollection(coll, id string) (int, error) {
+	m, err := s.fetch(coll)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	next := stringValue(m["first"])
+	for {
+		for _, item := range orderedItems(m) {
+			itemId := stringValue(item)
+			if im, ok := item.(map[string]interface{}); ok {
+				itemId = stringValue(im["id"])
+			}
+			if itemId == id {
+				n++
+			}
+		}
+		if len(next) == 0 {
+			return n, nil
+		}
+		if m, err = s.fetch(next); err != nil {
+			return 0, err
+		}
+		next = stringValue(m["next"])
+	}
+}
+
+func (s *selfTestRun) peerDeliveriesURL() string {
+	u := *s.cfg.Peer
+	u.Path = strings.TrimSuffix(u.Path, "/") + mockPeerDeliveriesPath
+	return u.String()
+}
+
+func (s *selfTestRun) resetPeer() error {
+	req, err := http.NewRequest(http.MethodDelete, s.peerDeliveriesURL(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// awaitDeliveries polls the mock peer until DeliveryWait passed, or until it
+// received more than want deliveries, and returns the last count seen.
+func (s *selfTestRun) awaitDeliveries(want int) (int, error) {
+	deadline := time.Now().Add(s.cfg.DeliveryWait)
+	for {
+		resp, err := s.cfg.Client.Get(s.peerDeliveriesURL())
+		if err != nil {
+			return 0, err
+		}
+		var d []MockDelivery
+		err = json.NewDecoder(resp.Body).Decode(&d)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		// Waiting out the deadline is the only way to tell no more
+		// deliveries are coming, even once there are want.
+		if len(d) > want || time.Now().After(deadline) {
+			return len(d), nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// statusMatches determines whether code is one of want, given either exactly
+// or by class as in "2xx".
+func statusMatches(code int, want []string) bool {
+	for _, w := range want {
+		if strings.HasSuffix(w, "xx") && len(w) == 3 {
+			if strconv.Itoa(code/100) == w[:1] {
+				return true
+			}
+		} else if strconv.Itoa(code) == w {
+			return true
+		}
+	}
+	return false
+}
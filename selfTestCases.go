@@ -0,0 +1,303 @@
+package report
+
+import (
+	"fmt"
+)
+
+// DefaultSelfTestCases returns the manual test cases of the README, as run by
+// "repsrv selftest".
+func DefaultSelfTestCases() []SelfTestCase {
+	zero, one := 0, 1
+	var cases []SelfTestCase
+
+	// Outbox: delivery to each kind of recipient.
+	for _, field := range []string{"to", "bto", "cc", "bcc"} {
+		cases = append(cases, SelfTestCase{
+			Name:        "outbox-delivers-" + field,
+			Section:     "7.1",
+			Level:       "MUST",
+			Requirement: fmt.Sprintf("Delivers an activity posted to the outbox to recipients in %q", field),
+			NeedsPeer:   true,
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   fmt.Sprintf(`{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "object": {"type": "Note", "content": "This is a test note."}, "actor": "{actor}", %q: "{testAccount}"}`, field),
+					Status: []string{"201"},
+					Checks: []SelfTestCheck{{Deliveries: &one}},
+				},
+			},
+		})
+	}
+
+	// Outbox: activities requiring an object.
+	for _, t := range []struct {
+		typ     string
+		section string
+	}{
+		{"Create", "6.2"},
+		{"Update", "6.3"},
+		{"Delete", "6.4"},
+		{"Follow", "6.5"},
+		{"Add", "6.6"},
+		{"Remove", "6.7"},
+		{"Like", "6.8"},
+		{"Block", "6.9"},
+		{"Undo", "6.10"},
+	} {
+		body := fmt.Sprintf(`{"@context": "https://www.w3.org/ns/activitystreams", "type": %q, "actor": "{actor}"}`, t.typ)
+		if t.typ == "Add" || t.typ == "Remove" {
+			body = fmt.Sprintf(`{"@context": "https://www.w3.org/ns/activitystreams", "type": %q, "target": {"id": "{new}/1", "type": "OrderedCollection"}, "actor": "{actor}"}`, t.typ)
+		}
+		cases = append(cases, SelfTestCase{
+			Name:        "outbox-requires-object-" + t.typ,
+			Section:     t.section,
+			Level:       "MUST",
+			Requirement: fmt.Sprintf("Rejects a %s posted to the outbox without an object", t.typ),
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   body,
+					Status: []string{"4xx"},
+				},
+			},
+		})
+	}
+
+	// Outbox: activities requiring a target.
+	for _, t := range []struct {
+		typ     string
+		section string
+	}{
+		{"Add", "6.6"},
+		{"Remove", "6.7"},
+	} {
+		cases = append(cases, SelfTestCase{
+			Name:        "outbox-requires-target-" + t.typ,
+			Section:     t.section,
+			Level:       "MUST",
+			Requirement: fmt.Sprintf("Rejects a %s posted to the outbox without a target", t.typ),
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   fmt.Sprintf(`{"@context": "https://www.w3.org/ns/activitystreams", "type": %q, "object": {"id": "{new}/1", "type": "Note"}, "actor": "{actor}"}`, t.typ),
+					Status: []string{"4xx"},
+				},
+			},
+		})
+	}
+
+	cases = append(cases,
+		SelfTestCase{
+			Name:        "outbox-dedups-recipients",
+			Section:     "7.1",
+			Level:       "MUST",
+			Requirement: "De-duplicates the final recipient list",
+			NeedsPeer:   true,
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "object": {"type": "Note", "content": "This is a test note."}, "actor": "{actor}", "to": ["{testAccount}", "{testAccount}"]}`,
+					Status: []string{"201"},
+					Checks: []SelfTestCheck{{Deliveries: &one}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "outbox-no-duplicate-on-receipt",
+			Section:     "7.1",
+			Level:       "MUST",
+			Requirement: "Does not deliver an activity twice to its own actor",
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "object": {"type": "Note", "content": "This is a test note."}, "actor": "{actor}", "to": "{actor}"}`,
+					Status: []string{"201"},
+					Save:   "created",
+					Checks: []SelfTestCheck{{Collection: "{outbox}", Contains: "{created}", AtMost: &one}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "outbox-no-block-delivery",
+			Section:     "6.9",
+			Level:       "SHOULD",
+			Requirement: "Does not deliver a Block to its object",
+			NeedsPeer:   true,
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Block", "object": "{testAccount}", "actor": "{actor}"}`,
+					Status: []string{"201"},
+					Save:   "block",
+					Checks: []SelfTestCheck{{Deliveries: &zero}},
+				},
+				// Later cases deliver to the test account again.
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Undo", "object": "{block}", "actor": "{actor}"}`,
+					Status: []string{"201"},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "inbox-dedups-activities",
+			Section:     "7",
+			Level:       "MUST",
+			Requirement: "De-duplicates activities received in the inbox",
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "id": "https://example.com/{unique}/1", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "This is a test note."}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+				},
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "id": "https://example.com/{unique}/1", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "This is a test note."}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+					Checks: []SelfTestCheck{{Collection: "{inbox}", Contains: "https://example.com/{unique}/1", AtMost: &one}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "inbox-update",
+			Section:     "7.3",
+			Level:       "SHOULD",
+			Requirement: "Accepts an Update of an object by its own actor, and rejects it from another",
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "id": "https://example.com/{unique}/1", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "This is a test note."}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+				},
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Update", "id": "https://example.com/{unique}/3", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "Completely new test note."}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+					Checks: []SelfTestCheck{{Collection: "{inbox}", Contains: "https://example.com/{unique}/3"}},
+				},
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Update", "id": "https://bad.example.com/{unique}/4", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "Not allowed to update the note."}, "actor": "https://bad.example.com/actor", "to": "{actor}"}`,
+					Status: []string{"4xx"},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "inbox-delete",
+			Section:     "7.4",
+			Level:       "SHOULD",
+			Requirement: "Accepts a Delete of an object by its own actor",
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "id": "https://example.com/{unique}/1", "object": {"type": "Note", "id": "https://example.com/{unique}/2", "content": "This is a test note."}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+				},
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Delete", "id": "https://example.com/{unique}/8", "object": {"type": "Note", "id": "https://example.com/{unique}/2"}, "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+					Checks: []SelfTestCheck{{Collection: "{inbox}", Contains: "https://example.com/{unique}/8"}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "inbox-follow",
+			Section:     "7.5",
+			Level:       "SHOULD",
+			Requirement: "Adds the actor of an accepted Follow to the followers collection",
+			Steps: []SelfTestStep{
+				{
+					Method: "POST",
+					Path:   "{inbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Follow", "id": "https://example.com/{unique}/9", "object": "{actor}", "actor": "https://example.com/actor", "to": "{actor}"}`,
+					Status: []string{"2xx"},
+					Checks: []SelfTestCheck{{Collection: "{followers}", Contains: "https://example.com/actor"}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "fetch-inbox",
+			Section:     "5.2",
+			Level:       "MUST",
+			Requirement: "Serves the inbox as an OrderedCollection",
+			Steps: []SelfTestStep{
+				{
+					Method: "GET",
+					Path:   "{inbox}",
+					Status: []string{"200"},
+					Checks: []SelfTestCheck{{Fetch: "{inbox}", Type: "OrderedCollection"}},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "fetch-missing-object",
+			Section:     "3.2",
+			Level:       "MUST",
+			Requirement: "Responds with 404 for objects that never existed",
+			Steps: []SelfTestStep{
+				{
+					Method: "GET",
+					Path:   "{new}/{unique}",
+					Status: []string{"404"},
+				},
+			},
+		},
+		SelfTestCase{
+			Name:        "fetch-deleted-object",
+			Section:     "6.4",
+			Level:       "SHOULD",
+			Requirement: "Serves a Tombstone, or responds with 410, for deleted objects",
+			Steps: []SelfTestStep{
+				{
+					Method:     "POST",
+					Path:       "{outbox}",
+					Auth:       true,
+					Body:       `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "object": {"type": "Note", "content": "This is a test note."}, "actor": "{actor}"}`,
+					Status:     []string{"201"},
+					Save:       "created",
+					SaveObject: "note",
+					Checks:     []SelfTestCheck{{Fetch: "{created}"}, {Fetch: "{note}", Type: "Note"}},
+				},
+				{
+					Method: "POST",
+					Path:   "{outbox}",
+					Auth:   true,
+					Body:   `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Delete", "object": "{note}", "actor": "{actor}"}`,
+					Status: []string{"201"},
+					Checks: []SelfTestCheck{{Fetch: "{note}", FetchStatus: []string{"200", "410"}, Type: "Tombstone"}},
+				},
+			},
+		},
+	)
+	return cases
+}
@@ -0,0 +1,137 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAwaitDeliveriesCountsLateDeliveries checks that an exact count of
+// deliveries fails when more arrive after the expected ones.
+func TestAwaitDeliveriesCountsLateDeliveries(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want int
+		// arrivals are the delays after which deliveries arrive.
+		arrivals []time.Duration
+		got      int
+	}{
+		{"none", 0, nil, 0},
+		{"unexpected", 0, []time.Duration{200 * time.Millisecond}, 1},
+		{"exact", 1, []time.Duration{0}, 1},
+		{"duplicate", 1, []time.Duration{0, 300 * time.Millisecond}, 2},
+		{"missing", 2, []time.Duration{0}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			mu := &sync.Mutex{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				d := []MockDelivery{}
+				for _, after := range test.arrivals {
+					if time.Since(start) >= after {
+						d = append(d, MockDelivery{Inbox: "inbox"})
+					}
+				}
+				json.NewEncoder(w).Encode(d)
+			}))
+			defer srv.Close()
+			peer, _ := url.Parse(srv.URL)
+			s := &selfTestRun{cfg: SelfTestConfig{Peer: peer, DeliveryWait: time.Second, Client: &http.Client{}}}
+			n, err := s.awaitDeliveries(test.want)
+			if err != nil {
+				t.Fatal(err)
+			} else if n != test.got {
+				t.Errorf("got %d deliveries, want %d", n, test.got)
+			}
+		})
+	}
+}
+
+func TestSelfTestNewVariable(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/users/report", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": srv.URL + "/users/report"})
+	})
+	var fetched string
+	mux.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
+		fetched = r.URL.Path
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": srv.URL + r.URL.Path})
+	})
+	actor, _ := url.Parse(srv.URL + "/users/report")
+	results, err := RunSelfTest(SelfTestConfig{Actor: actor, NewPath: "/objects/"}, []SelfTestCase{{
+		Name:  "new",
+		Steps: []SelfTestStep{{Method: http.MethodGet, Path: "{new}/1", Status: []string{"200"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	} else if !results[0].Passed {
+		t.Errorf("failed: %s", results[0].Error)
+	} else if fetched != "/objects/1" {
+		t.Errorf("fetched %s, want /objects/1", fetched)
+	}
+}
+
+func TestServerVersionFromNodeInfo(t *testing.T) {
+	at := newAccessTest(t)
+	at.app.activityVersion = "v1.0.0"
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeInfoPath, at.app.serveNodeInfo)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	actor, _ := url.Parse(srv.URL + "/users/report")
+	if v, err := ServerVersion(SelfTestConfig{Actor: actor}); err != nil {
+		t.Fatal(err)
+	} else if v != "v1.0.0" {
+		t.Errorf("got %q, want v1.0.0", v)
+	}
+}
+
+func TestReadSelfTestResults(t *testing.T) {
+	r, err := ReadSelfTestResults([]byte(`{"version": "v1.0.0", "results": [{"passed": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Version != "v1.0.0" || len(r.Results) != 1 || !r.Results[0].Passed {
+		t.Errorf("got %+v", r)
+	}
+}
+
+// TestDefaultSelfTestCasesRunTwice checks that the catalog leaves the server
+// as it found it, so that a case passing on the first run passes again.
+func TestDefaultSelfTestCasesRunTwice(t *testing.T) {
+	peer := newTestPeer(t, "test")
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	if err := SetReportMux(mux, "http", srv.Listener.Addr().String(), "/new"); err != nil {
+		t.Fatal(err)
+	}
+	actor, _ := url.Parse(srv.URL + usersPath + defaultActorName)
+	cfg := SelfTestConfig{
+		Actor:        actor,
+		Token:        defaultActorToken,
+		TestAccount:  peer.ActorIRI().String(),
+		Peer:         &url.URL{Scheme: "http", Host: peer.host},
+		DeliveryWait: 500 * time.Millisecond,
+	}
+	first, err := RunSelfTest(cfg, DefaultSelfTestCases())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RunSelfTest(cfg, DefaultSelfTestCases())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range first {
+		if first[i].Passed && !second[i].Passed {
+			t.Errorf("%s passed only on the first run: %s", first[i].Case.Name, second[i].Error)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/httpsig"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// SignaturesOff accepts inbox POSTs without looking at their
+	// signatures, SignaturesLog verifies them but only logs failures, and
+	// SignaturesEnforce rejects POSTs that fail verification.
+	SignaturesOff     = "off"
+	SignaturesLog     = "log"
+	SignaturesEnforce = "enforce"
+	// defaultClockSkew is how far the Date of a signed request may be from
+	// the server's clock.
+	defaultClockSkew = 5 * time.Minute
+)
+
+// requiredSignedHeaders must all be covered by the signature of an inbox POST,
+// and fetchSignedHeaders by that of a GET.
+var requiredSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+var fetchSignedHeaders = []string{"(request-target)", "host", "date"}
+
+var signedHeadersRegexp = regexp.MustCompile(`headers="([^"]*)"`)
+
+// validSignatureMode determines whether mode is one of the signature modes.
+func validSignatureMode(mode string) bool {
+	return mode == SignaturesOff || mode == SignaturesLog || mode == SignaturesEnforce
+}
+
+// signedHeaders returns the headers covered by the signature of r, which
+// default to the Date alone.
+func signedHeaders(r *http.Request) []string {
+	sig := r.Header.Get("Signature")
+	if len(sig) == 0 {
+		sig = r.Header.Get("Authorization")
+	}
+	m := signedHeadersRegexp.FindStringSubmatch(sig)
+	if m == nil {
+		return []string{"date"}
+	}
+	return strings.Fields(strings.ToLower(m[1]))
+}
+
+// checkDigest verifies that a SHA-256 digest in the Digest header matches b.
+func checkDigest(r *http.Request, b []byte) error {
+	h := sha256.Sum256(b)
+	want := base64.StdEncoding.EncodeToString(h[:])
+	digest := r.Header.Get("Digest")
+	for _, d := range strings.Split(digest, ",") {
+		parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "SHA-256") {
+			if parts[1] != want {
+				return fmt.Errorf("digest does not match the body")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no SHA-256 digest in %q", digest)
+}
+
+// actorId returns the id of the actor property of an activity, which may be
+// an IRI or an embedded object.
+func actorId(v interface{}) string {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return stringValue(t["id"])
+	case []interface{}:
+		if len(t) == 1 {
+			return actorId(t[0])
+		}
+	}
+	return stringValue(v)
+}
+
+// verifyRequestSignature verifies the HTTP signature of r: it must cover the
+// required headers, be dated within the clock skew, and be made with a key
+// whose owner is returned.
+func (a *app) verifyRequestSignature(c context.Context, r *http.Request, required []string) (string, error) {
+	signed := make(map[string]bool)
+	for _, h := range signedHeaders(r) {
+		signed[h] = true
+	}
+	for _, h := range required {
+		if !signed[h] {
+			return "", fmt.Errorf("signature does not cover %s", h)
+		}
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("invalid date: %s", err)
+	}
+	if skew := a.clock.Now().Sub(date); skew > a.clockSkew || skew < -a.clockSkew {
+		return "", fmt.Errorf("date %s is %s away from now", date, skew)
+	}
+	// The server moves the Host header to r.Host, where httpsig does not
+	// look for it.
+	if len(r.Header.Get("Host")) == 0 {
+		r.Header.Set("Host", r.Host)
+	}
+	v, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return "", err
+	}
+	if l, ok := a.findActor(func(l *localActor) bool { return v.KeyId() == l.keyURL.String() }); ok {
+		if err := v.Verify(l.pubKey, l.algo); err != nil {
+			return "", err
+		}
+		return l.actorURL.String(), nil
+	}
+	k, err := a.keys.Verify(c, v.KeyId(), func(k *remoteKey) error {
+		return v.Verify(k.pubKey, k.algo)
+	})
+	if err != nil {
+		return "", err
+	}
+	return k.owner.String(), nil
+}
+
+// verifySignature verifies the HTTP signature of an inbox POST: besides what
+// verifyRequestSignature checks, it must carry the digest of the body and be
+// made with a key of the activity's actor. It returns the id of that actor.
+func (a *app) verifySignature(c context.Context, r *http.Request) (string, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	if err := checkDigest(r, b); err != nil {
+		return "", err
+	}
+	owner, err := a.verifyRequestSignature(c, r, requiredSignedHeaders)
+	if err != nil {
+		return "", err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", err
+	}
+	if actor := actorId(m["actor"]); actor != owner {
+		return "", fmt.Errorf("signed by %s on behalf of %q", owner, actor)
+	}
+	return owner, nil
+}
+
+// verifyInbox verifies the signatures of inbox POSTs as set by the signature
+// mode. It has the signature of a pub.HandlerFunc, and handles only the POSTs
+// it rejects.
+func (a *app) verifyInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	if r.Method != http.MethodPost || a.signatureMode == SignaturesOff {
+		return false, nil
+	}
+	actor, err := a.verifySignature(c, r)
+	if err == nil {
+		log.Printf("verified signature of %s on POST to %q", actor, r.URL)
+		return false, nil
+	} else if a.signatureMode == SignaturesLog {
+		log.Printf("accepting POST to %q despite invalid signature: %s", r.URL, err)
+		return false, nil
+	}
+	log.Printf("rejecting POST to %q with invalid signature: %s", r.URL, err)
+	http.Error(w, "invalid signature", http.StatusUnauthorized)
+	return true, nil
+}
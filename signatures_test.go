@@ -0,0 +1,134 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-fed/httpsig"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signedPost POSTs activity to url as signed by peer, letting change alter the
+// request before it is signed.
+func signedPost(t *testing.T, url string, peer *MockPeer, activity map[string]interface{}, change func(r *http.Request)) int {
+	b, err := json.Marshal(activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(b)
+	req.Header.Set("Content-Type", activityJSONType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	change(req)
+	s, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, requiredSignedHeaders, httpsig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SignRequest(peer.privKey, peer.keyURL.String(), req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// inboxServer answers 200 to the POSTs the signature mode of at accepts.
+func inboxServer(t *testing.T, at *accessTest) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handled, err := at.app.verifyInbox(context.Background(), w, r); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if !handled {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifyInbox(t *testing.T) {
+	at := newAccessTest(t)
+	inbox := inboxServer(t, at).URL + "/users/alice/inbox"
+	for _, test := range []struct {
+		name   string
+		actor  *MockPeer
+		change func(r *http.Request)
+		valid  bool
+	}{
+		{"good", at.bob, func(r *http.Request) {}, true},
+		{"bad digest", at.bob, func(r *http.Request) {
+			r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+		}, false},
+		{"stale date", at.bob, func(r *http.Request) {
+			r.Header.Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		}, false},
+		{"key owner is not the actor", at.carol, func(r *http.Request) {}, false},
+	} {
+		for _, mode := range []string{SignaturesOff, SignaturesLog, SignaturesEnforce} {
+			t.Run(test.name+"/"+mode, func(t *testing.T) {
+				at.app.signatureMode = mode
+				activity := map[string]interface{}{
+					"type":   "Like",
+					"actor":  test.actor.ActorIRI().String(),
+					"object": at.alice.actorURL.String(),
+				}
+				want := http.StatusOK
+				if !test.valid && mode == SignaturesEnforce {
+					want = http.StatusUnauthorized
+				}
+				if got := signedPost(t, inbox, at.bob, activity, test.change); got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestKeyTypeSignerSignsWhatIsEnforced(t *testing.T) {
+	at := newAccessTest(t)
+	at.app.signatureMode = SignaturesEnforce
+	dave, err := at.app.addActor(context.Background(), ActorConfig{Name: "dave", Token: "dave-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"type":   "Like",
+		"actor":  dave.actorURL.String(),
+		"object": at.alice.actorURL.String(),
+	})
+	// As go-fed/activity posts to an inbox.
+	req, err := http.NewRequest(http.MethodPost, inboxServer(t, at).URL+"/users/alice/inbox", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	s, err := at.app.NewSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SignRequest(dave.privKey, dave.keyURL.String(), req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
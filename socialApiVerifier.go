@@ -7,60 +7,86 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 var _ pub.SocialAPIVerifier = &doNotUseThisItIsNotOAuth{}
 
+// stubAuthorizationCode is the only authorization code handed out, and the
+// only one exchanged for a token.
+const stubAuthorizationCode = "doNotDoThisInRealImplementations"
+
 // Do not use this. Do not look at this. In fact, delete this. Provides zero
 // security functionality. Mocks out the OAuth process. Does not actually do any
 // authenticating. Authorizes everybody. Do not use this. Do not use this as a
 // reference for actually implementing OAuth. I feel terrible for even writing
 // this code.
+//
+// Each actor is authorized by its own constant bearer token.
 type doNotUseThisItIsNotOAuth struct {
-	ActorURL  *url.URL
-	OutboxURL *url.URL
+	app *app
 }
 
 // Do not do this in real implementations. This does no actual authentication.
 // Do not do this in real implementation.
+//
+// The request is validated as in RFC 6749: without a client_id or a valid
+// redirect_uri it is refused outright, and other errors are redirected to the
+// client.
 func (o *doNotUseThisItIsNotOAuth) AuthorizeRequestWithoutActuallyDoingAnything(w http.ResponseWriter, r *http.Request) {
 	v := r.URL.Query()
-	var redir *url.URL
-	if rv, ok := v["redirect_uri"]; ok && len(rv) > 0 {
-		rs, err := url.QueryUnescape(rv[0])
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		redir, err = url.Parse(rs)
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
+	if len(v.Get("client_id")) == 0 {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	redir, err := url.Parse(v.Get("redirect_uri"))
+	if err != nil || !redir.IsAbs() || len(redir.Fragment) > 0 {
+		http.Error(w, "missing or invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	state := v.Get("state")
+	if rt := v.Get("response_type"); len(rt) == 0 {
+		redirectWithError(w, r, redir, state, oauthError{"invalid_request", "missing response_type"})
+		return
+	} else if rt != "code" {
+		redirectWithError(w, r, redir, state, oauthError{"unsupported_response_type", "only the code response type is supported"})
 		return
 	}
-	if state, ok := v["state"]; ok && len(state) > 0 {
-		for _, s := range state {
-			redir.Query().Add("state", s)
-		}
+	q := redir.Query()
+	q.Set("code", stubAuthorizationCode)
+	if len(state) > 0 {
+		q.Set("state", state)
 	}
-	redir.Query().Add("code", "doNotDoThisInRealImplementations")
+	redir.RawQuery = q.Encode()
+	noStore(w)
 	w.Header().Set("Location", redir.String())
-	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("Pragma", "no-cache")
 	w.WriteHeader(http.StatusFound)
 }
 
+// Do not do this in real implementations. Hands out the token of the first
+// actor to anyone asking with the code handed out by
+// AuthorizeRequestWithoutActuallyDoingAnything.
 func (o *doNotUseThisItIsNotOAuth) GrantBearerTokenWithoutActuallyDoingAnything(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", err.Error()})
+		return
+	} else if gt := r.Form.Get("grant_type"); gt != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"unsupported_grant_type", "only the authorization_code grant type is supported"})
+		return
+	} else if r.Form.Get("code") != stubAuthorizationCode {
+		writeOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "unknown code"})
+		return
+	}
+	l := o.app.firstActor()
+	if l == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	token := struct {
 		A string `json:"access_token"`
 		T string `json:"token_type"`
 	}{
-		A: "doNotDoThisInRealImplementations",
+		A: l.token,
 		T: "Bearer",
 	}
 	b, err := json.Marshal(token)
@@ -79,20 +105,32 @@ func (o *doNotUseThisItIsNotOAuth) GrantBearerTokenWithoutActuallyDoingAnything(
 // any and every one will be verified. Don't use this in real implementations.
 func (o *doNotUseThisItIsNotOAuth) Verify(r *http.Request) (authenticatedUser *url.URL, authn, authz bool, err error) {
 	bearer := r.Header.Get("Authorization")
-	if bearer != "Bearer doNotDoThisInRealImplementations" {
+	if !strings.HasPrefix(bearer, "Bearer ") {
 		return nil, false, false, fmt.Errorf("bad bearer %q", bearer)
 	}
-	return o.ActorURL, true, true, nil
+	l, ok := o.app.actorForToken(strings.TrimPrefix(bearer, "Bearer "))
+	if !ok {
+		return nil, false, false, fmt.Errorf("bad bearer %q", bearer)
+	}
+	return l.actorURL, true, true, nil
 }
 
 // VerifyForOutbox implements SocialAPIVerifier. Since this is a mock
 // implementation, any and every one will be verified. Don't use this in real
 // implementations.
 func (o *doNotUseThisItIsNotOAuth) VerifyForOutbox(r *http.Request, outbox *url.URL) (authn, authz bool, err error) {
-	if *outbox != *o.OutboxURL {
+	var actor *url.URL
+	actor, authn, authz, err = o.Verify(r)
+	if err != nil {
+		return
+	}
+	l, ok := o.app.actorForBox(outbox)
+	if !ok || *outbox != *l.outboxURL {
 		err = fmt.Errorf("bad outbox url %q", outbox)
 		return
+	} else if *actor != *l.actorURL {
+		// Authenticated, but as another actor.
+		authz = false
 	}
-	_, authn, authz, err = o.Verify(r)
 	return
 }
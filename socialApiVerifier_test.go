@@ -0,0 +1,111 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStubAuthorize(t *testing.T) {
+	o := &doNotUseThisItIsNotOAuth{app: newAccessTest(t).app}
+	for _, test := range []struct {
+		name   string
+		query  string
+		status int
+		// want is the query of the redirect, if any.
+		want url.Values
+	}{
+		{"granted", "client_id=c&redirect_uri=https://client.example/cb&response_type=code",
+			http.StatusFound, url.Values{"code": {stubAuthorizationCode}}},
+		{"state round-trip", "client_id=c&redirect_uri=https://client.example/cb&response_type=code&state=xyz",
+			http.StatusFound, url.Values{"code": {stubAuthorizationCode}, "state": {"xyz"}}},
+		{"redirect_uri query kept", "client_id=c&redirect_uri=https%3A%2F%2Fclient.example%2Fcb%3Fa%3D1&response_type=code",
+			http.StatusFound, url.Values{"a": {"1"}, "code": {stubAuthorizationCode}}},
+		{"missing client_id", "redirect_uri=https://client.example/cb&response_type=code",
+			http.StatusBadRequest, nil},
+		{"missing redirect_uri", "client_id=c&response_type=code",
+			http.StatusBadRequest, nil},
+		{"relative redirect_uri", "client_id=c&redirect_uri=/cb&response_type=code",
+			http.StatusBadRequest, nil},
+		{"redirect_uri with fragment", "client_id=c&redirect_uri=https://client.example/cb%23f&response_type=code",
+			http.StatusBadRequest, nil},
+		{"missing response_type", "client_id=c&redirect_uri=https://client.example/cb&state=xyz",
+			http.StatusFound, url.Values{"error": {"invalid_request"}, "error_description": {"missing response_type"}, "state": {"xyz"}}},
+		{"unsupported response_type", "client_id=c&redirect_uri=https://client.example/cb&response_type=token&state=xyz",
+			http.StatusFound, url.Values{"error": {"unsupported_response_type"}, "error_description": {"only the code response type is supported"}, "state": {"xyz"}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			o.AuthorizeRequestWithoutActuallyDoingAnything(rec, httptest.NewRequest(http.MethodGet, authPath+"?"+test.query, nil))
+			if rec.Code != test.status {
+				t.Fatalf("got %d, want %d", rec.Code, test.status)
+			}
+			if test.want == nil {
+				if loc := rec.Header().Get("Location"); len(loc) > 0 {
+					t.Errorf("redirected to %s", loc)
+				}
+				return
+			}
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := loc.Scheme + "://" + loc.Host + loc.Path; got != "https://client.example/cb" {
+				t.Errorf("redirected to %s", got)
+			}
+			if got := loc.Query(); got.Encode() != test.want.Encode() {
+				t.Errorf("redirected with %s, want %s", got.Encode(), test.want.Encode())
+			}
+		})
+	}
+}
+
+func TestStubToken(t *testing.T) {
+	at := newAccessTest(t)
+	o := &doNotUseThisItIsNotOAuth{app: at.app}
+	for _, test := range []struct {
+		name   string
+		form   url.Values
+		status int
+		want   map[string]interface{}
+	}{
+		{"granted", url.Values{"grant_type": {"authorization_code"}, "code": {stubAuthorizationCode}},
+			http.StatusOK, map[string]interface{}{"access_token": at.alice.token, "token_type": "Bearer"}},
+		{"missing grant_type", url.Values{"code": {stubAuthorizationCode}},
+			http.StatusBadRequest, map[string]interface{}{"error": "unsupported_grant_type", "error_description": "only the authorization_code grant type is supported"}},
+		{"unsupported grant_type", url.Values{"grant_type": {"password"}, "code": {stubAuthorizationCode}},
+			http.StatusBadRequest, map[string]interface{}{"error": "unsupported_grant_type", "error_description": "only the authorization_code grant type is supported"}},
+		{"missing code", url.Values{"grant_type": {"authorization_code"}},
+			http.StatusBadRequest, map[string]interface{}{"error": "invalid_grant", "error_description": "unknown code"}},
+		{"wrong code", url.Values{"grant_type": {"authorization_code"}, "code": {"guess"}},
+			http.StatusBadRequest, map[string]interface{}{"error": "invalid_grant", "error_description": "unknown code"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tokenPath, strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			o.GrantBearerTokenWithoutActuallyDoingAnything(rec, req)
+			if rec.Code != test.status {
+				t.Fatalf("got %d, want %d", rec.Code, test.status)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control is %q", got)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			for k, v := range test.want {
+				if got[k] != v {
+					t.Errorf("%s is %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
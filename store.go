@@ -0,0 +1,221 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Store keeps every object the report server hosts, including the actor and
+// its collections, keyed by id.
+//
+// Implementations must honor the pub.RWType passed to Get: an object fetched
+// with pub.ReadWrite stays locked to the lock key of the request context (see
+// LockKey) until the same request Puts it back or the request context is
+// done. Other requests block until then. Objects fetched with pub.Read are
+// only locked for the duration of the call.
+type Store interface {
+	// Get returns the object with the given id, or an error if there is
+	// none.
+	Get(c context.Context, id *url.URL, rw pub.RWType) (pub.PubObject, error)
+	// Put creates or replaces the object with the id of o, releasing any
+	// lock held on it by the request.
+	Put(c context.Context, o pub.PubObject) error
+	// Has determines whether an object with the given id exists.
+	Has(c context.Context, id *url.URL) (bool, error)
+	// Delete removes the object with the given id. Deleting an object that
+	// does not exist is not an error.
+	Delete(c context.Context, id *url.URL) error
+	// List returns the ids of all objects in the Store, in no particular
+	// order.
+	List(c context.Context) ([]*url.URL, error)
+	// NextId returns a number never before returned, used to mint the ids
+	// of new objects. If an error is returned alongside a nonzero id, the
+	// id is still unique for the lifetime of the Store but may be handed
+	// out again after a restart.
+	NextId(c context.Context) (int, error)
+}
+
+type lockKeyType string
+
+const lockKeyName = lockKeyType("lockKey")
+
+// LockKey returns the key identifying the request that owns the context, as
+// set up by the handlers of SetReportMux. Store implementations use it to tell
+// whether a request already holds the lock on an object.
+func LockKey(c context.Context) (key int, ok bool) {
+	key, ok = c.Value(lockKeyName).(int)
+	return
+}
+
+type lockObj struct {
+	obj pub.PubObject
+	mu  *sync.RWMutex
+	// who is the lock key holding mu for writing, or zero. It is read
+	// without holding mu, so it is only accessed atomically.
+	who int64
+}
+
+func (l *lockObj) owner() int {
+	return int(atomic.LoadInt64(&l.who))
+}
+
+func (l *lockObj) setOwner(who int) {
+	atomic.StoreInt64(&l.who, int64(who))
+}
+
+var _ Store = &memoryStore{}
+
+// memoryStore keeps everything in a map. Nothing survives a restart unless
+// the persistence hooks are set, in which case they are called while the
+// affected object is locked so that whatever they write is applied in the same
+// order as the changes in memory.
+type memoryStore struct {
+	db        map[string]*lockObj
+	dbMu      *sync.RWMutex
+	id        int
+	idMu      *sync.Mutex
+	persist   func(o pub.PubObject) error
+	remove    func(id *url.URL) error
+	persistId func(next int) error
+}
+
+// NewMemoryStore returns the default Store, which keeps all objects in memory.
+func NewMemoryStore() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		db:   make(map[string]*lockObj),
+		dbMu: &sync.RWMutex{},
+		id:   1,
+		idMu: &sync.Mutex{},
+	}
+}
+
+func (m *memoryStore) Get(c context.Context, id *url.URL, rw pub.RWType) (pub.PubObject, error) {
+	m.dbMu.RLock()
+	p, ok := m.db[id.String()]
+	m.dbMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s not found", id)
+	}
+	switch rw {
+	case pub.Read:
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+	case pub.ReadWrite:
+		who, ok := LockKey(c)
+		if !ok {
+			return nil, fmt.Errorf("no lock key to get %s for writing", id)
+		}
+		if p.owner() != who {
+			log.Printf("locking %s", id)
+			p.mu.Lock()
+			p.setOwner(who)
+			go func() {
+				<-c.Done()
+				if p.owner() == who {
+					log.Printf("unlocking %s", id)
+					p.mu.Unlock()
+				}
+			}()
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized pub.RWType: %v", rw)
+	}
+	return p.obj, nil
+}
+
+func (m *memoryStore) Put(c context.Context, o pub.PubObject) error {
+	id := o.GetId()
+	if id == nil {
+		return fmt.Errorf("id is nil")
+	}
+	m.dbMu.Lock()
+	if v, ok := m.db[id.String()]; ok {
+		m.dbMu.Unlock()
+		who, _ := LockKey(c)
+		vWho := v.owner()
+		// TODO: Use sync.Cond
+		if vWho == 0 || vWho != who {
+			log.Printf("locking %s", id)
+			v.mu.Lock()
+			v.setOwner(who)
+		}
+		var err error
+		if m.persist != nil {
+			err = m.persist(o)
+		}
+		if err == nil {
+			v.obj = o
+		}
+		v.setOwner(0)
+		log.Printf("unlocking %s", id)
+		v.mu.Unlock()
+		return err
+	}
+	defer m.dbMu.Unlock()
+	if m.persist != nil {
+		if err := m.persist(o); err != nil {
+			return err
+		}
+	}
+	m.db[id.String()] = &lockObj{
+		obj: o,
+		mu:  &sync.RWMutex{},
+	}
+	return nil
+}
+
+func (m *memoryStore) Has(c context.Context, id *url.URL) (bool, error) {
+	m.dbMu.RLock()
+	defer m.dbMu.RUnlock()
+	_, ok := m.db[id.String()]
+	return ok, nil
+}
+
+func (m *memoryStore) Delete(c context.Context, id *url.URL) error {
+	m.dbMu.Lock()
+	defer m.dbMu.Unlock()
+	if _, ok := m.db[id.String()]; !ok {
+		return nil
+	}
+	if m.remove != nil {
+		if err := m.remove(id); err != nil {
+			return err
+		}
+	}
+	delete(m.db, id.String())
+	return nil
+}
+
+func (m *memoryStore) List(c context.Context) ([]*url.URL, error) {
+	m.dbMu.RLock()
+	defer m.dbMu.RUnlock()
+	ids := make([]*url.URL, 0, len(m.db))
+	for k := range m.db {
+		id, err := url.Parse(k)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *memoryStore) NextId(c context.Context) (int, error) {
+	m.idMu.Lock()
+	defer m.idMu.Unlock()
+	id := m.id
+	m.id++
+	if m.persistId != nil {
+		return id, m.persistId(m.id)
+	}
+	return id, nil
+}